@@ -1,13 +1,23 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/protosio/app-store/db"
+	"github.com/protosio/app-store/directory"
 	"github.com/protosio/app-store/http"
+	"github.com/protosio/app-store/installer"
 	"github.com/protosio/app-store/registry"
+	"github.com/protosio/app-store/thumbnail"
 	"github.com/protosio/app-store/util"
 
 	"github.com/spf13/cobra"
@@ -15,10 +25,25 @@ import (
 
 var log = util.GetLogger()
 var config = util.GetConfig()
+var forceScan bool
+var scanDir string
+var importName string
+var importTag string
+var importPush bool
+var lintOutput string
+var lintStrict bool
+var quarantineRepository string
 
 var rootCmd = &cobra.Command{
 	Use:   "app-store",
 	Short: "Protos app store for serving application installers",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := installer.SetChannelPatterns(config.ChannelPatterns)
+		if err != nil {
+			log.Fatal(err)
+		}
+		thumbnail.SetAllowedHosts(config.ThumbnailHosts)
+	},
 }
 
 var serveCmd = &cobra.Command{
@@ -29,25 +54,266 @@ var serveCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		http.StartWebServer(config.Port)
+		registryClient, err := registry.NewClient(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registryClient.StartEventWorker()
+		if config.ScanSchedule != "" {
+			err = registryClient.StartReconciler(config.ScanSchedule)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		http.StartWebServer(config.Port, registryClient)
 	},
 }
 
 var scanCmd = &cobra.Command{
 	Use:   "scan",
-	Short: "Runs a full registry scan and imports all installers",
+	Short: "Runs a full registry (or local directory) scan and imports all installers",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := db.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if scanDir != "" {
+			dirSource, err := directory.New(scanDir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			signature, err := registry.UnverifiableSignature(config.SignaturePolicy, "directory "+scanDir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			_, _, err = installer.Sync(dirSource, signature)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		registryClient, err := registry.NewClient(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = registryClient.FullScan(forceScan)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Imports an installer from a docker save archive or an OCI image layout",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		err := db.Connect()
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = registry.FullScan()
+		archive, err := registry.OpenArchive(args[0], importName, importTag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer archive.Close()
+
+		metadata, err := archive.Metadata()
+		if err != nil {
+			log.Fatal(err)
+		}
+		metadata.Signature, err = registry.UnverifiableSignature(config.SignaturePolicy, "archive "+args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if importPush {
+			registryClient, err := registry.NewClient(config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = registryClient.PushArchive(archive)
+			if err != nil {
+				log.Fatal(err)
+			}
+			metadata.Source = registry.SourceName
+		}
+		err = installer.Add(archive.Name, archive.Tag, metadata)
+		if err != nil {
+			log.Fatal(err)
+		}
+		latest, err := installer.IsThumbnailVersion(archive.Name, archive.Tag)
+		if err != nil {
+			log.Warnf("Could not check the thumbnail version: %s", err.Error())
+		} else if !latest {
+			log.Infof("Not updating the thumbnail of %s from %s, which is not its latest version", archive.Name, archive.Tag)
+		} else {
+			thumbnail, found, err := archive.Thumbnail()
+			if err != nil {
+				log.Warnf("Could not retrieve thumbnail: %s", err.Error())
+			} else if found {
+				err = installer.SetThumbnail(archive.Name, thumbnail)
+				if err != nil {
+					log.Warnf("Could not save thumbnail: %s", err.Error())
+				}
+			}
+		}
+		log.Infof("Imported installer %s(%s) with digest %s", archive.Name, archive.Tag, archive.Digest)
+	},
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint <repository:tag | label file>",
+	Short: "Checks the installer metadata of an image or a local label file and reports all the problems found",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if lintOutput != "text" && lintOutput != "json" {
+			log.Fatalf("Invalid output format '%s'. Valid formats are text and json", lintOutput)
+		}
+		labels, err := lintLabels(args[0])
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		report := installer.Lint(labels)
+		if lintOutput == "json" {
+			out, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(out))
+		} else {
+			for _, problem := range report.Problems {
+				fmt.Println(problem.String())
+			}
+			if len(report.Problems) == 0 {
+				fmt.Println("No problems found")
+			}
+		}
+
+		if !report.Valid || (lintStrict && len(report.Problems) > 0) {
+			os.Exit(1)
+		}
 	},
 }
 
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Manages the images that failed to import",
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the quarantined images and the problems that prevented their import",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := db.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		images, err := registry.GetQuarantinedImages(quarantineRepository)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, image := range images {
+			fmt.Printf("%s:%s (%s) failed %d time(s), last at %s\n", image.Repository, image.Tag, image.Digest, image.Attempts, image.LastFailed.Format(time.RFC3339))
+			for _, problem := range image.Problems {
+				fmt.Printf("  %s\n", problem.String())
+			}
+		}
+	},
+}
+
+var quarantineReimportCmd = &cobra.Command{
+	Use:   "reimport [repository:tag]",
+	Short: "Retries the import of one or all quarantined images",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := db.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		registryClient, err := registry.NewClient(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		repository, tag := quarantineRepository, ""
+		if len(args) == 1 {
+			repository, tag = splitImageReference(args[0])
+		}
+		results, err := registryClient.Reimport(repository, tag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failed := 0
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			}
+		}
+		log.Infof("Re-imported %d quarantined image(s), %d still failing", len(results)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// splitImageReference splits an image reference into repository and tag. The tag defaults to latest
+func splitImageReference(reference string) (string, string) {
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		return reference[:i], reference[i+1:]
+	}
+	return reference, "latest"
+}
+
+// lintLabels returns the labels to lint. If target is an existing file it's read as a label file, otherwise it's
+// treated as an image reference and the labels are retrieved from the registry
+func lintLabels(target string) (map[string]string, error) {
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		return readLabelFile(target)
+	}
+
+	name, tag := splitImageReference(target)
+	registryClient, err := registry.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return registryClient.ImageLabels(name, tag)
+}
+
+// readLabelFile reads labels from a JSON object (like the directory source manifests) or from a docker label file,
+// which holds one key=value pair per line. Field names can be used with or without the metadata label prefix
+func readLabelFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read label file")
+	}
+
+	fields := map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error unmarshalling label file %s", path)
+		}
+		return installer.PrefixLabels(fields), nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for nr := 1; scanner.Scan(); nr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid line %d in label file %s (expected <key>=<value>)", nr, path)
+		}
+		fields[strings.TrimSpace(parts[0])] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read label file %s", path)
+	}
+	return installer.PrefixLabels(fields), nil
+}
+
 //Execute is the entry point to the command line menu
 func Execute() {
 	util.SetLogLevel(logrus.DebugLevel)
@@ -59,12 +325,46 @@ func Execute() {
 
 func init() {
 	serveCmd.PersistentFlags().IntVarP(&config.Port, "port", "p", 8000, "port to listen on")
+	serveCmd.PersistentFlags().StringVarP(&config.EventSecret, "eventsecret", "", "", "shared secret used to authenticate registry notifications")
+	serveCmd.PersistentFlags().StringVarP(&config.ScanSchedule, "scanschedule", "", "", "interval (e.g. 6h) or cron expression (e.g. '0 3 * * *') for periodic registry scans. Disabled if empty")
+	serveCmd.PersistentFlags().StringSliceVarP(&config.EventSources, "eventsources", "", []string{}, "IP addresses, CIDR ranges or host names of the registries allowed to send notifications (all if empty)")
+	serveCmd.PersistentFlags().BoolVarP(&config.InsecureEvents, "insecureevents", "", false, "accept registry notifications without authentication when no event secret is configured")
+	serveCmd.PersistentFlags().StringSliceVarP(&config.TrustedProxies, "trustedproxies", "", []string{}, "IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted")
 	rootCmd.PersistentFlags().StringVarP(&config.DBHost, "dbhost", "", "database", "database host to connect to")
 	rootCmd.PersistentFlags().StringVarP(&config.DBName, "dbname", "", "installers", "database name to use")
 	rootCmd.PersistentFlags().StringVarP(&config.DBPass, "dbpass", "", "", "database password to use")
 	rootCmd.PersistentFlags().StringVarP(&config.DBUser, "dbuser", "", "installers", "database user to use")
 	rootCmd.PersistentFlags().IntVarP(&config.DBPort, "dbport", "", 5432, "database port to use")
+	rootCmd.PersistentFlags().StringVarP(&config.RegistryHost, "registryhost", "", "docker-registry", "Docker registry host to connect to")
+	rootCmd.PersistentFlags().IntVarP(&config.RegistryPort, "registryport", "", 5000, "Docker registry port to connect to")
+	rootCmd.PersistentFlags().StringVarP(&config.RegistryScheme, "registryscheme", "", "http", "scheme used to connect to the Docker registry (http or https)")
+	rootCmd.PersistentFlags().StringVarP(&config.RegistryCA, "registryca", "", "", "path to a PEM encoded CA certificate used to verify the Docker registry")
+	rootCmd.PersistentFlags().StringVarP(&config.RegistryUser, "registryuser", "", "", "user used to authenticate against the Docker registry")
+	rootCmd.PersistentFlags().StringVarP(&config.RegistryPass, "registrypass", "", "", "password used to authenticate against the Docker registry")
+	rootCmd.PersistentFlags().IntVarP(&config.RegistryPageSize, "registrypagesize", "", 100, "number of repositories or tags requested per page from the Docker registry")
+
+	rootCmd.PersistentFlags().StringVarP(&config.SignatureKeys, "signaturekeys", "", "", "directory with the PEM encoded public keys of trusted publishers, named <publisher>.pub")
+	rootCmd.PersistentFlags().StringVarP(&config.SignaturePolicy, "signaturepolicy", "", "disabled", "how images without a valid signature are handled (disabled, flag or reject)")
+	rootCmd.PersistentFlags().StringSliceVarP(&config.ThumbnailHosts, "thumbnailhosts", "", []string{}, "hosts (and their subdomains) installer thumbnails can be downloaded from. URL thumbnails are ignored if empty")
+	rootCmd.PersistentFlags().StringSliceVarP(&config.ChannelPatterns, "channelpatterns", "", installer.DefaultChannelPatterns, "<channel>=<regexp> patterns, tried in order, that assign version tags to the stable, beta or nightly channel. Other tags are stable")
+	rootCmd.PersistentFlags().IntVarP(&config.ScanConcurrency, "concurrency", "c", 4, "number of images processed in parallel during registry scans")
+	scanCmd.PersistentFlags().StringVarP(&scanDir, "dir", "d", "", "import installer manifests from a local directory instead of the registry")
+	scanCmd.PersistentFlags().BoolVarP(&forceScan, "force", "f", false, "re-import all images, even if their digest did not change")
+
+	importCmd.PersistentFlags().StringVarP(&importName, "name", "n", "", "installer name, if it can't be determined from the archive")
+	importCmd.PersistentFlags().StringVarP(&importTag, "tag", "t", "", "installer version, if it can't be determined from the archive")
+	importCmd.PersistentFlags().BoolVarP(&importPush, "push", "", false, "also push the image to the configured registry")
+
+	lintCmd.PersistentFlags().StringVarP(&lintOutput, "output", "o", "text", "output format (text or json)")
+	lintCmd.PersistentFlags().BoolVarP(&lintStrict, "strict", "", false, "also fail if warnings are found")
+
+	quarantineCmd.PersistentFlags().StringVarP(&quarantineRepository, "repository", "r", "", "only include the images of this repository")
+	quarantineCmd.AddCommand(quarantineListCmd)
+	quarantineCmd.AddCommand(quarantineReimportCmd)
 
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(quarantineCmd)
 }
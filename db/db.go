@@ -108,6 +108,19 @@ func Update(installer Installer) error {
 	return nil
 }
 
+// Delete removes the installer with the provided name from the database
+func Delete(name string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := psql.Delete("installer").Where("name = ?", name).ToSql()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Performing delete query: {%s} using arguments {%v}", sql, args)
+	db.MustExec(sql, args...)
+	return nil
+}
+
 // Get returns an Installer based on the provided filter
 func Get(filter map[string]interface{}) (Installer, bool, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
@@ -144,7 +157,8 @@ func GetAll() ([]Installer, error) {
 	return installers, nil
 }
 
-// SearchProvider searches installers based on the provides field
+// SearchProvider searches installers based on the provides field. Only the versions that provide the type, with
+// any version, are returned
 func SearchProvider(providerType string) ([]Installer, error) {
 
 	sql := `
@@ -164,14 +178,18 @@ FROM
 		installer,
 		jsonb_each(version_metadata)
 	WHERE
-		VALUE -> 'provides' @> ANY (ARRAY [$1]::jsonb[])) installer
+		EXISTS (
+			SELECT 1
+			FROM jsonb_array_elements_text(
+				CASE WHEN jsonb_typeof(VALUE -> 'provides') = 'array' THEN VALUE -> 'provides' ELSE '[]'::jsonb END
+			) AS provided
+			WHERE trim(split_part(provided, '@', 1)) = $1)) installer
 GROUP BY
 	installer.id,
     installer.name,
 	installer.thumbnail;`
-	// sorounding the search term in quotes is required for the pq jsonb search
-	param := "\"" + providerType + "\""
-	args := []interface{}{param}
+	// provides entries can carry a version (e.g. "dns@2"), so only the part before the version is compared
+	args := []interface{}{providerType}
 
 	installers, err := dbQuery(sql, args)
 	if err != nil {
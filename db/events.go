@@ -0,0 +1,94 @@
+package db
+
+import (
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+)
+
+// Event represents a registry event as saved by the database
+type Event struct {
+	ID            string             `db:"id"`
+	Action        string             `db:"action"`
+	Repository    string             `db:"repository"`
+	Tag           string             `db:"tag"`
+	Payload       sqlxTypes.JSONText `db:"payload"`
+	Status        string             `db:"status"`
+	Attempts      int                `db:"attempts"`
+	LastError     string             `db:"last_error"`
+	ReceivedAt    time.Time          `db:"received_at"`
+	NextAttemptAt time.Time          `db:"next_attempt_at"`
+}
+
+var eventColumns = []string{"id", "action", "repository", "tag", "payload", "status", "attempts", "last_error", "received_at", "next_attempt_at"}
+
+// InsertEvent persists a registry event. Events are keyed by their id, so an event that is already in the database
+// is ignored. The returned boolean indicates if the event was inserted
+func InsertEvent(event Event) (bool, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := psql.
+		Insert("registry_event").Columns("id", "action", "repository", "tag", "payload", "status").
+		Values(event.ID, event.Action, event.Repository, event.Tag, event.Payload, event.Status).
+		Suffix("ON CONFLICT (id) DO NOTHING").ToSql()
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec(sql, args...)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted == 1, nil
+}
+
+// UpdateEvent updates the processing state of a registry event
+func UpdateEvent(event Event) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	sql, args, err := psql.Update("registry_event").SetMap(map[string]interface{}{
+		"status":          event.Status,
+		"attempts":        event.Attempts,
+		"last_error":      event.LastError,
+		"next_attempt_at": event.NextAttemptAt,
+	}).Where("id = ?", event.ID).ToSql()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Performing update query: {%s} using arguments {%v}", sql, args)
+	_, err = db.Exec(sql, args...)
+	return err
+}
+
+// GetDueEvents returns the events with the provided status that are due for processing, oldest first
+func GetDueEvents(status string, limit uint64) ([]Event, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Select(eventColumns...).From("registry_event").
+		Where(sq.And{sq.Eq{"status": status}, sq.Expr("next_attempt_at <= now()")}).
+		OrderBy("received_at").Limit(limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	err = db.Select(&events, sql, args...)
+	return events, err
+}
+
+// GetEvents returns the registry events matching the provided filter, newest first
+func GetEvents(filter map[string]interface{}) ([]Event, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Select(eventColumns...).From("registry_event").
+		Where(stripNilValues(filter)).OrderBy("received_at DESC").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	err = db.Select(&events, sql, args...)
+	return events, err
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+)
+
+// TryAdvisoryLock tries to acquire a Postgres session level advisory lock, which is held on a dedicated connection
+// so it is shared between all the app store processes using the same database. If the lock was acquired, the
+// returned function releases it
+func TryAdvisoryLock(key int64) (func(), bool, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+
+	return func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+		if err != nil {
+			log.Errorf("Failed to release advisory lock %d: %s", key, err.Error())
+		}
+		conn.Close()
+	}, true, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+)
+
+// QuarantinedImage represents an image tag that failed to import, as saved by the database
+type QuarantinedImage struct {
+	Repository    string             `db:"repository"`
+	Tag           string             `db:"tag"`
+	Digest        string             `db:"digest"`
+	Problems      sqlxTypes.JSONText `db:"problems"`
+	Attempts      int                `db:"attempts"`
+	FirstFailedAt time.Time          `db:"first_failed_at"`
+	LastFailedAt  time.Time          `db:"last_failed_at"`
+}
+
+var quarantineColumns = []string{"repository", "tag", "digest", "problems", "attempts", "first_failed_at", "last_failed_at"}
+
+// SaveQuarantinedImage records a failed import. If the image tag is already quarantined, its digest and problems
+// are replaced and the number of attempts is incremented
+func SaveQuarantinedImage(image QuarantinedImage) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Insert("quarantined_image").Columns("repository", "tag", "digest", "problems").
+		Values(image.Repository, image.Tag, image.Digest, image.Problems).
+		Suffix("ON CONFLICT (repository, tag) DO UPDATE SET digest = EXCLUDED.digest, problems = EXCLUDED.problems, " +
+			"attempts = quarantined_image.attempts + 1, last_failed_at = now()").ToSql()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Performing insert query: {%s} using arguments {%v}", sql, args)
+	_, err = db.Exec(sql, args...)
+	return err
+}
+
+// DeleteQuarantinedImage removes an image tag from the quarantine
+func DeleteQuarantinedImage(repository string, tag string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Delete("quarantined_image").Where(sq.Eq{"repository": repository, "tag": tag}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(sql, args...)
+	return err
+}
+
+// GetQuarantinedImages returns the quarantined images matching the provided filter, most recent failures first
+func GetQuarantinedImages(filter map[string]interface{}) ([]QuarantinedImage, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Select(quarantineColumns...).From("quarantined_image").
+		Where(stripNilValues(filter)).OrderBy("last_failed_at DESC").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	images := []QuarantinedImage{}
+	err = db.Select(&images, sql, args...)
+	return images, err
+}
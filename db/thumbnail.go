@@ -0,0 +1,75 @@
+package db
+
+import (
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Thumbnail represents a size variant of an installer thumbnail as saved by the database
+type Thumbnail struct {
+	InstallerID string `db:"installer_id"`
+	Size        string `db:"size"`
+	ContentType string `db:"content_type"`
+	ETag        string `db:"etag"`
+	Data        []byte `db:"data"`
+}
+
+// SaveThumbnails replaces all the thumbnail variants of an installer
+func SaveThumbnails(installerID string, thumbnails []Thumbnail) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql, args, err := psql.Delete("installer_thumbnail").Where("installer_id = ?", installerID).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	insert := psql.Insert("installer_thumbnail").Columns("installer_id", "size", "content_type", "etag", "data")
+	for _, thumbnail := range thumbnails {
+		insert = insert.Values(installerID, thumbnail.Size, thumbnail.ContentType, thumbnail.ETag, thumbnail.Data)
+	}
+	sql, args, err = insert.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sql, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteThumbnails removes all the thumbnail variants of an installer
+func DeleteThumbnails(installerID string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Delete("installer_thumbnail").Where("installer_id = ?", installerID).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(sql, args...)
+	return err
+}
+
+// GetThumbnail returns a thumbnail variant of an installer, and a boolean indicating if it was found
+func GetThumbnail(installerID string, size string) (Thumbnail, bool, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.Select("installer_id", "size", "content_type", "etag", "data").From("installer_thumbnail").
+		Where(sq.Eq{"installer_id": installerID, "size": size}).ToSql()
+	if err != nil {
+		return Thumbnail{}, false, err
+	}
+
+	thumbnails := []Thumbnail{}
+	err = db.Select(&thumbnails, sql, args...)
+	if err != nil || len(thumbnails) == 0 {
+		return Thumbnail{}, false, err
+	}
+	return thumbnails[0], true, nil
+}
@@ -0,0 +1,126 @@
+package directory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/installer"
+	"github.com/protosio/app-store/util"
+)
+
+var log = util.GetLogger()
+
+// manifestExt is the file extension of installer manifests
+const manifestExt = ".json"
+
+var _ installer.Source = (*Source)(nil)
+
+// Source reads installers from a local directory tree. Every installer version is described by a JSON manifest
+// stored at <root>/<installer name>/<version>.json. Installer names can contain slashes, like repository names.
+// A manifest is a JSON object holding the same fields as the image labels, with or without the label prefix:
+//
+//	{"description": "DNS server", "provides": "dns", "publicports": "53/udp"}
+type Source struct {
+	root string
+}
+
+// New returns a source that reads installer manifests from the provided directory
+func New(root string) (*Source, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open installer directory")
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &Source{root: root}, nil
+}
+
+// Name returns the name of the source
+func (s *Source) Name() string {
+	return "directory"
+}
+
+// Installers returns the names of all the installers that have at least one manifest
+func (s *Source) Installers() ([]string, error) {
+	names := map[string]bool{}
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != manifestExt {
+			return nil
+		}
+		dir, err := filepath.Rel(s.root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if dir == "." {
+			log.Warnf("Ignoring manifest %s which is not in an installer directory", path)
+			return nil
+		}
+		names[filepath.ToSlash(dir)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	installers := []string{}
+	for name := range names {
+		installers = append(installers, name)
+	}
+	sort.Strings(installers)
+	return installers, nil
+}
+
+// Versions returns all the versions of an installer, based on the manifest file names
+func (s *Source) Versions(name string) ([]string, error) {
+	files, err := ioutil.ReadDir(s.installerDir(name))
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != manifestExt {
+			continue
+		}
+		versions = append(versions, strings.TrimSuffix(file.Name(), manifestExt))
+	}
+	return versions, nil
+}
+
+// Metadata parses the manifest of an installer version. The platform id is derived from the manifest digest, so
+// any change to the manifest results in a new platform id
+func (s *Source) Metadata(name string, version string) (installer.InstallerMetadata, error) {
+	path := filepath.Join(s.installerDir(name), version+manifestExt)
+	manifestJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return installer.InstallerMetadata{}, err
+	}
+
+	var fields map[string]string
+	err = json.Unmarshal(manifestJSON, &fields)
+	if err != nil {
+		return installer.InstallerMetadata{}, errors.Wrapf(err, "Error unmarshalling manifest %s", path)
+	}
+	metadata, err := installer.ParseMetadata(installer.PrefixLabels(fields))
+	if err != nil {
+		return metadata, errors.Wrapf(err, "Could not parse manifest %s", path)
+	}
+	digest := sha256.Sum256(manifestJSON)
+	metadata.PlatformID = name + "@sha256:" + hex.EncodeToString(digest[:])
+	return metadata, nil
+}
+
+func (s *Source) installerDir(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
@@ -4,17 +4,27 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/protosio/app-store/installer"
 	"github.com/protosio/app-store/registry"
+	"github.com/protosio/app-store/thumbnail"
 	"github.com/protosio/app-store/util"
 )
 
 var log = util.GetLogger()
+var registryClient *registry.Client
 
-// StartWebServer starts the webserver on the provided port
-func StartWebServer(port int) {
+// StartWebServer starts the webserver on the provided port. Registry events are processed using the provided client
+func StartWebServer(port int, client *registry.Client) {
+	registryClient = client
+	if config.EventSecret == "" && config.InsecureEvents {
+		log.Warn("No event secret configured. Registry events will be accepted without authentication")
+	} else if config.EventSecret == "" {
+		log.Warn("No event secret configured. Registry events will be rejected")
+	}
 	log.Infof("Starting the web server on port %d", port)
 	mainRtr := mux.NewRouter().StrictSlash(true)
 	r := mainRtr.PathPrefix("/api/v1").Subrouter()
@@ -22,12 +32,76 @@ func StartWebServer(port int) {
 	r.HandleFunc("/search", search).Methods("GET")
 	r.HandleFunc("/installers/all", getAllInstallers).Methods("GET")
 	r.HandleFunc("/installers/{installerID}", getInstaller).Methods("GET")
+	r.HandleFunc("/installers/{installerID}/plan", getInstallPlan).Methods("GET")
+	r.HandleFunc("/installers/{installerID}/thumbnail", getThumbnail).Methods("GET")
+	r.HandleFunc("/installers/{installerID}/thumbnail/{size}", getThumbnail).Methods("GET")
+	r.HandleFunc("/capabilities", getCapabilities).Methods("GET")
+	r.HandleFunc("/capabilities/{capabilityName}", getCapability).Methods("GET")
 	r.HandleFunc("/event", processEvent).Methods("POST")
+	r.HandleFunc("/events", requireEventAuth(getEvents)).Methods("GET")
+	r.HandleFunc("/events/{eventID}", requireEventAuth(getEvent)).Methods("GET")
+	r.HandleFunc("/scan/status", getScanStatus).Methods("GET")
+	r.HandleFunc("/quarantine", getQuarantine).Methods("GET")
+	r.HandleFunc("/lint", lintImage).Methods("GET")
+	r.HandleFunc("/lint", lintLabels).Methods("POST")
 
 	log.Fatal(http.ListenAndServe(":8000", r))
 
 }
 
+// requestedPlatform returns the platform a client asked for using the os, arch and variant query parameters. An
+// empty architecture means no platform filtering was requested
+func requestedPlatform(r *http.Request) (string, string, string) {
+	queryParams := r.URL.Query()
+	return queryParams.Get("os"), queryParams.Get("arch"), queryParams.Get("variant")
+}
+
+// filterPlatform removes the installer versions that are not available for the platform requested by the client
+func filterPlatform(r *http.Request, installers map[string]installer.Installer) map[string]installer.Installer {
+	os, arch, variant := requestedPlatform(r)
+	if os == "" && arch == "" {
+		return installers
+	}
+	return installer.FilterPlatform(installers, os, arch, variant)
+}
+
+// requestedChannel returns the release channel a client asked for using the channel query parameter. Clients
+// that don't ask for a channel get the stable versions
+func requestedChannel(r *http.Request) (string, error) {
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		return installer.ChannelStable, nil
+	} else if channel == installer.ChannelAll {
+		return channel, nil
+	}
+	return channel, installer.ValidateChannel(channel)
+}
+
+// includePrereleases checks if pre-releases should be considered for the latest version. That's the case if the
+// client asked for them using the prerelease query parameter, or if it follows a channel other than stable
+func includePrereleases(r *http.Request, channel string) bool {
+	return r.URL.Query().Get("prerelease") == "true" || channel != installer.ChannelStable
+}
+
+// addVersionInfo adds the sorted versions and the latest version to the installers
+func addVersionInfo(r *http.Request, channel string, installers map[string]installer.Installer) map[string]installer.Installer {
+	prerelease := includePrereleases(r, channel)
+	for id, inst := range installers {
+		installers[id] = inst.WithVersionInfo(prerelease)
+	}
+	return installers
+}
+
+// filterInstallers applies the platform and channel filters requested by the client and adds the version info
+func filterInstallers(w http.ResponseWriter, r *http.Request, installers map[string]installer.Installer) (map[string]installer.Installer, bool) {
+	channel, err := requestedChannel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return addVersionInfo(r, channel, installer.FilterChannel(filterPlatform(r, installers), channel)), true
+}
+
 func getAllInstallers(w http.ResponseWriter, r *http.Request) {
 	installers, err := installer.GetAll()
 	if err != nil {
@@ -35,7 +109,9 @@ func getAllInstallers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal error: can't retrieve installers", http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(installers)
+	if installers, ok := filterInstallers(w, r, installers); ok {
+		json.NewEncoder(w).Encode(installers)
+	}
 	return
 }
 
@@ -49,10 +125,128 @@ func getInstaller(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal error: can't retrieve installer "+installerID, http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(installer)
+	if os, arch, variant := requestedPlatform(r); os != "" || arch != "" {
+		var available bool
+		installer, available = installer.FilterPlatform(os, arch, variant)
+		if !available {
+			http.Error(w, "Installer "+installerID+" is not available for the requested platform", http.StatusNotFound)
+			return
+		}
+	}
+	channel, err := requestedChannel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	installer, available := installer.FilterChannel(channel)
+	if !available {
+		http.Error(w, "Installer "+installerID+" has no versions in the "+channel+" channel", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(installer.WithVersionInfo(includePrereleases(r, channel)))
 	return
 }
 
+// getInstallPlan resolves the requirements of an installer version into an install plan. Providers are searched in
+// the requested channel and platform. If no version is provided, the latest version in the channel is used
+func getInstallPlan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	installerID := vars["installerID"]
+
+	channel, err := requestedChannel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inst, err := installer.Get(installerID)
+	if err != nil {
+		log.Errorf("Can't retrieve installer %s: %v", installerID, err)
+		http.Error(w, "Internal error: can't retrieve installer "+installerID, http.StatusInternalServerError)
+		return
+	}
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		var found bool
+		inst, _ = inst.FilterChannel(channel)
+		version, found = inst.LatestVersion(includePrereleases(r, channel))
+		if !found {
+			http.Error(w, "Installer "+installerID+" has no release in the "+channel+" channel", http.StatusNotFound)
+			return
+		}
+	}
+
+	if _, found := inst.VersionMetadata[version]; !found {
+		http.Error(w, "Installer "+installerID+" does not have version "+version, http.StatusNotFound)
+		return
+	}
+
+	plan, err := installer.Resolve(inst, version, func(providerType string) (map[string]installer.Installer, error) {
+		providers, err := installer.Search(providerType, "")
+		if err != nil {
+			return nil, err
+		}
+		return installer.FilterChannel(filterPlatform(r, providers), channel), nil
+	})
+	if err != nil {
+		log.Errorf("Can't resolve installer %s(%s): %v", installerID, version, err)
+		http.Error(w, "Internal error: can't resolve installer "+installerID, http.StatusInternalServerError)
+		return
+	}
+	if !plan.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(plan)
+}
+
+// getThumbnail serves a size variant of an installer thumbnail. The original image is served if no size is provided
+func getThumbnail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	installerID := vars["installerID"]
+	size := vars["size"]
+	if size == "" {
+		size = thumbnail.Original
+	} else if _, valid := thumbnail.Sizes[size]; !valid && size != thumbnail.Original {
+		http.Error(w, "Invalid thumbnail size "+size, http.StatusBadRequest)
+		return
+	}
+
+	thumb, found, err := installer.GetThumbnail(installerID, size)
+	if err != nil {
+		log.Errorf("Can't retrieve thumbnail for installer %s: %v", installerID, err)
+		http.Error(w, "Internal error: can't retrieve thumbnail for installer "+installerID, http.StatusInternalServerError)
+		return
+	} else if !found {
+		http.Error(w, "Could not find thumbnail for installer "+installerID, http.StatusNotFound)
+		return
+	}
+
+	// the URL is stable, so clients revalidate using the ETag once the cached copy expires
+	w.Header().Set("ETag", thumb.ETag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Header.Get("If-None-Match") == thumb.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", thumb.ContentType)
+	w.Write(thumb.Data)
+}
+
+func getCapabilities(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(installer.GetCapabilityDefinitions())
+}
+
+func getCapability(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	capabilityName := vars["capabilityName"]
+
+	capability, found := installer.GetCapabilityDefinition(capabilityName)
+	if !found {
+		http.Error(w, "Could not find capability "+capabilityName, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(capability)
+}
+
 func search(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 	if val, ok := queryParams["general"]; ok {
@@ -65,19 +259,27 @@ func search(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Internal error: can't perform search", http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(installers)
+		if installers, ok := filterInstallers(w, r, installers); ok {
+			json.NewEncoder(w).Encode(installers)
+		}
 		return
 	} else if val, ok := queryParams["provides"]; ok {
 		if len(val) == 0 {
 			http.Error(w, "No value for query parameter", http.StatusInternalServerError)
 		}
+		if _, err := installer.ParseRequiredType(val[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		installers, err := installer.Search(val[0], "")
 		if err != nil {
 			log.Errorf("Can't perform search: %v", err)
 			http.Error(w, "Internal error: can't perform search", http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(installers)
+		if installers, ok := filterInstallers(w, r, installers); ok {
+			json.NewEncoder(w).Encode(installers)
+		}
 		return
 	}
 	http.Error(w, "'provides' is the only valid search parameter", http.StatusInternalServerError)
@@ -91,6 +293,20 @@ func processEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = authenticateEvent(r, bodyJSON)
+	if err != nil {
+		log.Warnf("Rejected unauthenticated event request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = checkEventSource(r)
+	if err != nil {
+		log.Warnf("Rejected event request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
 	var events registry.Events
 	err = json.Unmarshal(bodyJSON, &events)
 	if err != nil {
@@ -98,6 +314,90 @@ func processEvent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// events are only acknowledged after they are persisted, otherwise the registry won't deliver them again
+	err = registryClient.QueueEvents(events.Events)
+	if err != nil {
+		log.Errorf("Failed to queue registry events: %v", err)
+		http.Error(w, "Internal error: can't queue events", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	registry.ProcessEvents(events.Events)
+}
+
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	events, err := registry.GetQueuedEvents(queryParams.Get("status"), queryParams.Get("repository"))
+	if err != nil {
+		log.Errorf("Can't retrieve events: %v", err)
+		http.Error(w, "Internal error: can't retrieve events", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+func getEvent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventID := vars["eventID"]
+
+	event, found, err := registry.GetQueuedEvent(eventID)
+	if err != nil {
+		log.Errorf("Can't retrieve event %s: %v", eventID, err)
+		http.Error(w, "Internal error: can't retrieve event "+eventID, http.StatusInternalServerError)
+		return
+	} else if !found {
+		http.Error(w, "Could not find event "+eventID, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(event)
+}
+
+func getScanStatus(w http.ResponseWriter, r *http.Request) {
+	status, enabled := registryClient.ReconcileStatus()
+	if !enabled {
+		http.Error(w, "Periodic registry scans are not enabled", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func getQuarantine(w http.ResponseWriter, r *http.Request) {
+	images, err := registry.GetQuarantinedImages(r.URL.Query().Get("repository"))
+	if err != nil {
+		log.Errorf("Can't retrieve quarantined images: %v", err)
+		http.Error(w, "Internal error: can't retrieve quarantined images", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(images)
+}
+
+// lintImage checks the installer metadata of an image in the registry, provided as ?image=<repository>:<tag>
+func lintImage(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("image")
+	i := strings.LastIndex(image, ":")
+	if i <= strings.LastIndex(image, "/") {
+		http.Error(w, "Query parameter 'image' should be in the <repository>:<tag> format", http.StatusBadRequest)
+		return
+	}
+
+	labels, err := registryClient.ImageLabels(image[:i], image[i+1:])
+	if errors.Cause(err) == registry.ErrNotFound {
+		http.Error(w, "Could not find image "+image+" in the registry", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Errorf("Can't retrieve labels for image %s: %v", image, err)
+		http.Error(w, "Can't retrieve image "+image+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(installer.Lint(labels))
+}
+
+// lintLabels checks the installer metadata labels provided as a JSON object in the request body
+func lintLabels(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]string
+	err := json.NewDecoder(r.Body).Decode(&fields)
+	if err != nil {
+		http.Error(w, "Request body should be a JSON object of labels: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(installer.Lint(installer.PrefixLabels(fields)))
 }
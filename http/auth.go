@@ -0,0 +1,143 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/protosio/app-store/util"
+)
+
+// signatureHeader holds the hex encoded HMAC-SHA256 of the request body, computed using the event secret
+const signatureHeader = "X-Signature-256"
+
+var config = util.GetConfig()
+
+// authenticateEvent checks that an event request carries either the shared secret as a bearer token, or a valid
+// HMAC signature of the body. If no event secret is configured, requests are only accepted when insecure events
+// were explicitly allowed
+func authenticateEvent(r *http.Request, body []byte) error {
+	if config.EventSecret == "" {
+		if config.InsecureEvents {
+			return nil
+		}
+		return errors.New("no event secret configured")
+	}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token != auth && subtle.ConstantTimeCompare([]byte(token), []byte(config.EventSecret)) == 1 {
+			return nil
+		}
+		return errors.New("invalid bearer token")
+	}
+
+	if signature := r.Header.Get(signatureHeader); signature != "" {
+		signature = strings.TrimPrefix(signature, "sha256=")
+		expected, err := hex.DecodeString(signature)
+		if err != nil {
+			return fmt.Errorf("malformed %s header", signatureHeader)
+		}
+		mac := hmac.New(sha256.New, []byte(config.EventSecret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return nil
+		}
+		return errors.New("invalid body signature")
+	}
+
+	return errors.New("no credentials provided")
+}
+
+// addressMatches checks if an IP address matches one of the provided IP addresses, CIDR ranges or host names. A port
+// is ignored, so entries like "docker-registry:5000" keep working
+func addressMatches(addr string, entries []string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if host, _, err := net.SplitHostPort(entry); err == nil {
+			entry = host
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		addrs, err := net.LookupHost(entry)
+		if err != nil {
+			log.Debugf("Failed to resolve event source %s: %v", entry, err)
+			continue
+		}
+		for _, a := range addrs {
+			if net.ParseIP(a).Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestSource returns the address a request originates from. The X-Forwarded-For header is only used when the
+// request comes from a trusted proxy, and the last address that is not a trusted proxy is taken from it
+func requestSource(r *http.Request) string {
+	source := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		source = host
+	}
+	if len(config.TrustedProxies) == 0 || !addressMatches(source, config.TrustedProxies) {
+		return source
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+		source = addr
+		if !addressMatches(addr, config.TrustedProxies) {
+			break
+		}
+	}
+	return source
+}
+
+// checkEventSource makes sure an event request originates from one of the allowed registries, matched against the
+// network address of the request. An empty allow-list accepts all sources
+func checkEventSource(r *http.Request) error {
+	if len(config.EventSources) == 0 {
+		return nil
+	}
+	source := requestSource(r)
+	if !addressMatches(source, config.EventSources) {
+		return fmt.Errorf("request originates from '%s' which is not an allowed registry", source)
+	}
+	return nil
+}
+
+// requireEventAuth protects the event queue endpoints with the same credentials as the event endpoint
+func requireEventAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := authenticateEvent(r, []byte{})
+		if err != nil {
+			log.Warnf("Rejected unauthenticated request for %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,135 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/util"
+)
+
+// RiskLevel classifies how much access a capability grants to an application
+type RiskLevel string
+
+// Capability risk levels
+const (
+	RiskLow    = RiskLevel("low")
+	RiskMedium = RiskLevel("medium")
+	RiskHigh   = RiskLevel("high")
+)
+
+// Capability is a permission requested by an installer, with optional arguments that narrow it down. The JSON
+// field names are kept capitalized to stay compatible with the previous map based format
+type Capability struct {
+	Name string            `json:"Name"`
+	Args map[string]string `json:"Args,omitempty"`
+}
+
+// CapabilityDefinition describes a capability known to the app store
+type CapabilityDefinition struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Risk        RiskLevel `json:"risk"`
+	Args        []string  `json:"args,omitempty"`
+}
+
+// knownCapabilities holds all the capabilities an installer can request
+var knownCapabilities = map[string]CapabilityDefinition{
+	"ResourceProvider": {
+		Name:        "ResourceProvider",
+		Description: "Provides resources (like DNS records or certificates) to other applications",
+		Risk:        RiskMedium,
+		Args:        []string{"type"},
+	},
+	"ResourceConsumer": {
+		Name:        "ResourceConsumer",
+		Description: "Requests resources (like DNS records or certificates) from other applications",
+		Risk:        RiskLow,
+		Args:        []string{"type"},
+	},
+	"InternetAccess": {
+		Name:        "InternetAccess",
+		Description: "Makes outbound connections to the internet",
+		Risk:        RiskLow,
+	},
+	"GetInformation": {
+		Name:        "GetInformation",
+		Description: "Reads information about the Protos instance, like its domain and network",
+		Risk:        RiskLow,
+	},
+	"PublicDNS": {
+		Name:        "PublicDNS",
+		Description: "Manages the public DNS records of the Protos domain",
+		Risk:        RiskHigh,
+		Args:        []string{"domain"},
+	},
+	"AuthUser": {
+		Name:        "AuthUser",
+		Description: "Authenticates users against the Protos user database",
+		Risk:        RiskMedium,
+	},
+	"UserAdmin": {
+		Name:        "UserAdmin",
+		Description: "Creates, modifies and removes Protos users",
+		Risk:        RiskHigh,
+	},
+}
+
+// GetCapabilityDefinitions returns all the known capabilities, sorted by name
+func GetCapabilityDefinitions() []CapabilityDefinition {
+	definitions := []CapabilityDefinition{}
+	for _, definition := range knownCapabilities {
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Name < definitions[j].Name })
+	return definitions
+}
+
+// GetCapabilityDefinition returns a known capability based on its name
+func GetCapabilityDefinition(name string) (CapabilityDefinition, bool) {
+	definition, found := knownCapabilities[name]
+	return definition, found
+}
+
+// validate makes sure the capability and its arguments are known
+func (c Capability) validate() error {
+	definition, found := knownCapabilities[c.Name]
+	if !found {
+		return fmt.Errorf("unknown capability '%s'", c.Name)
+	}
+	for arg := range c.Args {
+		if allowed, _ := util.StringInSlice(arg, definition.Args); !allowed {
+			return fmt.Errorf("capability '%s' does not accept argument '%s'", c.Name, arg)
+		}
+	}
+	return nil
+}
+
+// ParseCapabilities parses the capabilities label. The label is either a JSON array of capabilities with optional
+// arguments, or a comma separated list of capability names
+func ParseCapabilities(value string) ([]Capability, error) {
+	capabilities := []Capability{}
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		err := json.Unmarshal([]byte(value), &capabilities)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid capabilities definition")
+		}
+	} else {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				capabilities = append(capabilities, Capability{Name: name})
+			}
+		}
+	}
+
+	for _, capability := range capabilities {
+		err := capability.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,143 @@
+package installer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Release channels, ordered from the most to the least stable
+const (
+	ChannelStable  = "stable"
+	ChannelBeta    = "beta"
+	ChannelNightly = "nightly"
+	// ChannelAll is used when filtering, to include the versions of all channels
+	ChannelAll = "all"
+)
+
+var channels = []string{ChannelStable, ChannelBeta, ChannelNightly}
+
+// DefaultChannelPatterns classify nightly builds by name and semantic versions with a pre-release part as beta
+var DefaultChannelPatterns = []string{
+	ChannelNightly + `=^(nightly|dev|edge)([-._].*)?$`,
+	ChannelBeta + `=^v?[0-9]+(\.[0-9]+)*-.+$`,
+}
+
+type channelPattern struct {
+	channel string
+	pattern *regexp.Regexp
+}
+
+var channelPatterns = mustCompileChannelPatterns(DefaultChannelPatterns)
+
+// channelRank returns the position of a channel in the stability order, or -1 if the channel is unknown
+func channelRank(channel string) int {
+	for i, c := range channels {
+		if c == channel {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateChannel checks if channel is one of the known release channels
+func ValidateChannel(channel string) error {
+	if channelRank(channel) < 0 {
+		return fmt.Errorf("Unknown channel '%s'. Valid channels are: %s", channel, strings.Join(channels, ", "))
+	}
+	return nil
+}
+
+func compileChannelPatterns(patterns []string) ([]channelPattern, error) {
+	compiled := []channelPattern{}
+	for _, pattern := range patterns {
+		parts := strings.SplitN(pattern, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid channel pattern '%s' (expected <channel>=<regexp>)", pattern)
+		}
+		err := ValidateChannel(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid regular expression for channel %s", parts[0])
+		}
+		compiled = append(compiled, channelPattern{channel: parts[0], pattern: re})
+	}
+	return compiled, nil
+}
+
+func mustCompileChannelPatterns(patterns []string) []channelPattern {
+	compiled, err := compileChannelPatterns(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// SetChannelPatterns replaces the tag patterns used to determine the channel of a version. Each pattern has the
+// <channel>=<regexp> format and they are tried in order. Tags that don't match any pattern are stable
+func SetChannelPatterns(patterns []string) error {
+	compiled, err := compileChannelPatterns(patterns)
+	if err != nil {
+		return err
+	}
+	channelPatterns = compiled
+	return nil
+}
+
+// ChannelForTag determines the channel of a version based on its tag
+func ChannelForTag(tag string) string {
+	for _, cp := range channelPatterns {
+		if cp.pattern.MatchString(tag) {
+			return cp.channel
+		}
+	}
+	return ChannelStable
+}
+
+// versionChannel returns the channel of a version. The channel label takes precedence over the tag patterns
+func versionChannel(tag string, metadata InstallerMetadata) string {
+	if metadata.Channel != "" {
+		return metadata.Channel
+	}
+	return ChannelForTag(tag)
+}
+
+// InChannel checks if a version should be visible to clients following channel. A channel includes the versions
+// of all the channels that are more stable than it, so beta clients also get stable versions
+func InChannel(versionChannel string, channel string) bool {
+	if channel == ChannelAll {
+		return true
+	}
+	return channelRank(versionChannel) <= channelRank(channel)
+}
+
+// FilterChannel removes the versions of the installer that are not part of the channel, and sets the channel of
+// the remaining ones. The returned boolean indicates if any version is left
+func (i Installer) FilterChannel(channel string) (Installer, bool) {
+	versions := map[string]InstallerMetadata{}
+	for version, metadata := range i.VersionMetadata {
+		metadata.Channel = versionChannel(version, metadata)
+		if InChannel(metadata.Channel, channel) {
+			versions[version] = metadata
+		}
+	}
+	i.VersionMetadata = versions
+	return i, len(versions) > 0
+}
+
+// FilterChannel removes the installer versions that are not part of the channel. Installers that are left without
+// any version are removed
+func FilterChannel(installers map[string]Installer, channel string) map[string]Installer {
+	filtered := map[string]Installer{}
+	for id, installer := range installers {
+		if installer, available := installer.FilterChannel(channel); available {
+			filtered[id] = installer
+		}
+	}
+	return filtered
+}
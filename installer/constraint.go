@@ -0,0 +1,158 @@
+package installer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// providerTypeRegexp matches a provider type, followed by an optional version or version constraint
+var providerTypeRegexp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9_.-]*)\s*(.*)$`)
+
+// ProvidedType is a provider type offered by an installer version, optionally versioned (e.g. "dns@2")
+type ProvidedType struct {
+	Type    string
+	Version *semver.Version
+}
+
+// RequiredType is a provider type required by an installer version, optionally with a version constraint
+// (e.g. "dns>=2,<3")
+type RequiredType struct {
+	Type       string
+	Constraint *semver.Constraints
+
+	constraint string
+}
+
+// constraintOperators are the characters that mark a requires entry as having a version constraint
+const constraintOperators = "<>=~^!, "
+
+// ParseProvidedType parses a provides entry. Entries without a version (e.g. "dns") are still valid. Entries that
+// were accepted before provider types were versioned (e.g. "k8s/ingress" or "dns@latest") are kept as plain,
+// unversioned provider types
+func ParseProvidedType(entry string) (ProvidedType, error) {
+	provided, err := parseProvidedType(entry)
+	if err != nil {
+		legacy := strings.TrimSpace(strings.SplitN(entry, "@", 2)[0])
+		if legacy == "" {
+			return provided, err
+		}
+		return ProvidedType{Type: legacy}, nil
+	}
+	return provided, nil
+}
+
+// parseProvidedType strictly parses a versioned provides entry
+func parseProvidedType(entry string) (ProvidedType, error) {
+	parts := strings.SplitN(strings.TrimSpace(entry), "@", 2)
+	match := providerTypeRegexp.FindStringSubmatch(parts[0])
+	if match == nil || match[2] != "" {
+		return ProvidedType{}, fmt.Errorf("Invalid provider type '%s'", entry)
+	}
+	provided := ProvidedType{Type: match[1]}
+	if len(parts) == 2 {
+		version, err := semver.NewVersion(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return provided, errors.Wrapf(err, "Invalid version for provider type '%s'", entry)
+		}
+		provided.Version = version
+	}
+	return provided, nil
+}
+
+// ParseRequiredType parses a requires entry. Entries without a constraint (e.g. "dns") are still valid. Entries
+// without a constraint operator that were accepted before provider types were versioned (e.g. "k8s/ingress") are
+// kept as plain provider types
+func ParseRequiredType(entry string) (RequiredType, error) {
+	required, err := parseRequiredType(entry)
+	if err != nil {
+		legacy := strings.TrimSpace(entry)
+		if legacy == "" || strings.ContainsAny(legacy, constraintOperators) {
+			return required, err
+		}
+		return RequiredType{Type: legacy}, nil
+	}
+	return required, nil
+}
+
+// parseRequiredType strictly parses a requires entry with an optional version constraint
+func parseRequiredType(entry string) (RequiredType, error) {
+	match := providerTypeRegexp.FindStringSubmatch(strings.TrimSpace(entry))
+	if match == nil {
+		return RequiredType{}, fmt.Errorf("Invalid provider type '%s'", entry)
+	}
+	required := RequiredType{Type: match[1]}
+	if match[2] != "" {
+		constraint, err := semver.NewConstraint(match[2])
+		if err != nil {
+			return required, errors.Wrapf(err, "Invalid version constraint for provider type '%s'", entry)
+		}
+		required.Constraint = constraint
+		required.constraint = match[2]
+	}
+	return required, nil
+}
+
+// SatisfiedBy checks if a provided type fulfills the requirement. Unversioned provided types only fulfill
+// requirements without a constraint
+func (r RequiredType) SatisfiedBy(provided ProvidedType) bool {
+	if r.Type != provided.Type {
+		return false
+	}
+	if r.Constraint == nil {
+		return true
+	}
+	return provided.Version != nil && r.Constraint.Check(provided.Version)
+}
+
+func (r RequiredType) String() string {
+	return r.Type + r.constraint
+}
+
+// Satisfies checks if an installer version provides a type that fulfills the requirement
+func (m InstallerMetadata) Satisfies(required RequiredType) bool {
+	for _, entry := range m.Provides {
+		provided, err := ParseProvidedType(entry)
+		if err == nil && required.SatisfiedBy(provided) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterRequirement removes the installer versions that don't fulfill the requirement. Installers that are left
+// without any version are removed
+func FilterRequirement(installers map[string]Installer, required RequiredType) map[string]Installer {
+	filtered := map[string]Installer{}
+	for id, installer := range installers {
+		versions := map[string]InstallerMetadata{}
+		for version, metadata := range installer.VersionMetadata {
+			if metadata.Satisfies(required) {
+				versions[version] = metadata
+			}
+		}
+		if len(versions) > 0 {
+			installer.VersionMetadata = versions
+			filtered[id] = installer
+		}
+	}
+	return filtered
+}
+
+// splitRequires splits a comma separated list of requires entries. Since constraints are also comma separated
+// (e.g. "dns>=2,<3,mail"), items that don't start with a provider type are appended to the previous entry
+func splitRequires(value string) []string {
+	entries := []string{}
+	for _, item := range strings.Split(value, ",") {
+		trimmed := strings.TrimSpace(item)
+		if len(entries) > 0 && trimmed != "" && !providerTypeRegexp.MatchString(trimmed) {
+			entries[len(entries)-1] += "," + trimmed
+			continue
+		}
+		entries = append(entries, item)
+	}
+	return entries
+}
@@ -0,0 +1,105 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitRequires(t *testing.T) {
+	tests := []struct {
+		value   string
+		entries []string
+	}{
+		{"dns", []string{"dns"}},
+		{"dns,mail", []string{"dns", "mail"}},
+		{"dns>=2,<3,mail", []string{"dns>=2,<3", "mail"}},
+		{"dns>=2, <3", []string{"dns>=2,<3"}},
+		{"mail, dns>=2,<3", []string{"mail", " dns>=2,<3"}},
+		{"k8s/ingress,dns@latest", []string{"k8s/ingress", "dns@latest"}},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			entries := splitRequires(test.value)
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("Expected %q, got %q", test.entries, entries)
+			}
+		})
+	}
+}
+
+func TestParseRequiredType(t *testing.T) {
+	tests := []struct {
+		entry string
+		// strict is false if the entry is only accepted as a legacy provider type
+		strict     bool
+		valid      bool
+		typ        string
+		constraint string
+	}{
+		{"dns", true, true, "dns", ""},
+		{" dns ", true, true, "dns", ""},
+		{"dns>=2", true, true, "dns", ">=2"},
+		{"dns >=2, <3", true, true, "dns", ">=2, <3"},
+		{"dns>=2,<3", true, true, "dns", ">=2,<3"},
+		{"dns>=2, <3", true, true, "dns", ">=2, <3"},
+		{"dns>=2,<3,mail", false, false, "", ""},
+		{"dns>=two", false, false, "", ""},
+		{"k8s/ingress", false, true, "k8s/ingress", ""},
+		{"dns@latest", false, true, "dns@latest", ""},
+		{"", false, false, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.entry, func(t *testing.T) {
+			_, err := parseRequiredType(test.entry)
+			if (err == nil) != test.strict {
+				t.Errorf("Expected strict parsing to succeed: %t, got error %v", test.strict, err)
+			}
+
+			required, err := ParseRequiredType(test.entry)
+			if (err == nil) != test.valid {
+				t.Fatalf("Expected parsing to succeed: %t, got error %v", test.valid, err)
+			} else if err != nil {
+				return
+			}
+			if required.Type != test.typ {
+				t.Errorf("Expected type '%s', got '%s'", test.typ, required.Type)
+			}
+			if required.String() != test.typ+test.constraint || (required.Constraint != nil) != (test.constraint != "") {
+				t.Errorf("Expected constraint '%s', got '%s'", test.constraint, required.constraint)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		provides  []string
+		required  string
+		satisfies bool
+	}{
+		{[]string{"dns@2.1.0"}, "dns", true},
+		{[]string{"dns@2.1.0"}, "dns>=2,<3", true},
+		{[]string{"dns@3.0.0"}, "dns>=2,<3", false},
+		{[]string{"dns@2.1.0"}, "mail", false},
+		{[]string{"mail@1.0.0", "dns@2.1.0"}, "dns^2", true},
+		{[]string{"dns"}, "dns", true},
+		{[]string{"dns"}, "dns>=2", false},
+		{[]string{"dns@latest"}, "dns", true},
+		{[]string{"dns@latest"}, "dns>=2", false},
+		{[]string{"k8s/ingress"}, "k8s/ingress", true},
+		{[]string{"k8s/ingress"}, "k8s", false},
+		{[]string{}, "dns", false},
+	}
+	for _, test := range tests {
+		t.Run(test.required, func(t *testing.T) {
+			required, err := ParseRequiredType(test.required)
+			if err != nil {
+				t.Fatal(err)
+			}
+			metadata := InstallerMetadata{Provides: test.provides}
+			if metadata.Satisfies(required) != test.satisfies {
+				t.Errorf("Expected %q satisfying '%s' to be %t", test.provides, test.required, test.satisfies)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -14,17 +16,130 @@ import (
 
 var log = util.GetLogger()
 
+// installerLocks serializes the read-modify-write cycles performed on the same installer, which can otherwise
+// race when several versions of it are imported concurrently
+var installerLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// lockInstaller locks the installer with the provided name and returns the function that unlocks it
+func lockInstaller(name string) func() {
+	installerLocks.Lock()
+	lock, found := installerLocks.locks[name]
+	if !found {
+		lock = &sync.Mutex{}
+		installerLocks.locks[name] = lock
+	}
+	installerLocks.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
 // InstallerMetadata holds metadata for the installer
 type InstallerMetadata struct {
-	Params          []string            `json:"params"`
-	Provides        []string            `json:"provides"`
-	Requires        []string            `json:"requires"`
-	PublicPorts     []util.Port         `json:"publicports"`
-	Description     string              `json:"description"`
-	PlatformID      string              `json:"platformid"`
-	PlatformType    string              `json:"platformtype"`
-	PersistancePath string              `json:"persistancepath"`
-	Capabilities    []map[string]string `json:"capabilities"`
+	Params           []string            `json:"params"`
+	ParamDefinitions []Param             `json:"paramdefinitions,omitempty"`
+	Provides         []string            `json:"provides"`
+	Requires         []string            `json:"requires"`
+	PublicPorts      []util.Port         `json:"publicports"`
+	Description      string              `json:"description"`
+	PlatformID       string              `json:"platformid"`
+	PlatformType     string              `json:"platformtype"`
+	PersistancePath  string              `json:"persistancepath"`
+	Capabilities     []Capability        `json:"capabilities"`
+	Platforms        map[string]Platform `json:"platforms,omitempty"`
+	Source           string              `json:"source,omitempty"`
+	Signature        *Signature          `json:"signature,omitempty"`
+	Image            *ImageInfo          `json:"image,omitempty"`
+	Channel          string              `json:"channel,omitempty"`
+}
+
+// Platform holds the image digest and installer labels of an installer version for a specific platform
+type Platform struct {
+	OS           string            `json:"os"`
+	Architecture string            `json:"architecture"`
+	Variant      string            `json:"variant,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// Key returns the os/arch/variant string used to index a platform
+func (p Platform) Key() string {
+	key := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		key = key + "/" + p.Variant
+	}
+	return key
+}
+
+// Supports checks if the platform matches the provided os, architecture and variant. Empty values match anything
+func (p Platform) Supports(os string, arch string, variant string) bool {
+	return (os == "" || p.OS == os) && (arch == "" || p.Architecture == arch) && (variant == "" || p.Variant == variant)
+}
+
+// Signature states of an installer version
+const (
+	SignatureVerified = "verified"
+	SignatureUnsigned = "unsigned"
+	SignatureInvalid  = "invalid"
+)
+
+// Signature holds the outcome of verifying the signature of an installer version
+type Signature struct {
+	Status    string `json:"status"`
+	Publisher string `json:"publisher,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// Verifier identifies the signature policy and publisher keys the version was checked with
+	Verifier string `json:"verifier,omitempty"`
+}
+
+// ImageInfo holds details about the image of an installer version, taken from the image manifest and config and
+// from the standard org.opencontainers.image.* annotations
+type ImageInfo struct {
+	Size     int64      `json:"size"`
+	Created  *time.Time `json:"created,omitempty"`
+	Version  string     `json:"version,omitempty"`
+	Source   string     `json:"source,omitempty"`
+	Licenses string     `json:"licenses,omitempty"`
+	Authors  string     `json:"authors,omitempty"`
+	URL      string     `json:"url,omitempty"`
+}
+
+// FilterPlatform returns the installers that have at least one version available for the provided platform, keeping
+// only the versions that support it. Versions without platform information, like the ones imported from a local
+// directory, are platform independent and always kept
+func FilterPlatform(installers map[string]Installer, os string, arch string, variant string) map[string]Installer {
+	filtered := map[string]Installer{}
+	for id, installer := range installers {
+		installer, ok := installer.FilterPlatform(os, arch, variant)
+		if ok {
+			filtered[id] = installer
+		}
+	}
+	return filtered
+}
+
+// FilterPlatform returns a copy of the installer that only contains the versions available for the provided
+// platform, and a boolean indicating if any versions are left
+func (i Installer) FilterPlatform(os string, arch string, variant string) (Installer, bool) {
+	versions := map[string]InstallerMetadata{}
+	for version, metadata := range i.VersionMetadata {
+		if len(metadata.Platforms) == 0 {
+			versions[version] = metadata
+			continue
+		}
+		for _, platform := range metadata.Platforms {
+			if platform.Supports(os, arch, variant) {
+				versions[version] = metadata
+				break
+			}
+		}
+	}
+	i.VersionMetadata = versions
+	return i, len(versions) > 0
 }
 
 // Installer represents an application installer, but not a specific versio of it.
@@ -33,6 +148,8 @@ type Installer struct {
 	Name            string                       `json:"name,omitempty"`
 	Thumbnail       string                       `json:"thumbnail,omitempty"`
 	VersionMetadata map[string]InstallerMetadata `json:"versions"`
+	SortedVersions  []Version                    `json:"sortedversions,omitempty"`
+	Latest          string                       `json:"latest,omitempty"`
 }
 
 func dbToInstaller(dbinstaller db.Installer) (Installer, error) {
@@ -75,6 +192,7 @@ func installerToDB(installer Installer) (db.Installer, error) {
 
 // Add takes an installer and persists it to the database
 func Add(name string, version string, metadata InstallerMetadata) error {
+	defer lockInstaller(name)()
 	id := util.String2SHA1(name)
 	dbinstaller, found, err := db.Get(map[string]interface{}{"name": name})
 	if err != nil {
@@ -128,6 +246,52 @@ func Add(name string, version string, metadata InstallerMetadata) error {
 	return nil
 }
 
+// RemoveVersion removes the versions of an installer that match either the provided tag or image digest. If no
+// versions are left after the removal, the installer itself is removed from the database
+func RemoveVersion(name string, tag string, digest string) error {
+	defer lockInstaller(name)()
+	dbinstaller, found, err := db.Get(map[string]interface{}{"name": name})
+	if err != nil {
+		return err
+	} else if !found {
+		log.Debugf("Installer %s not found in db. Nothing to remove", name)
+		return nil
+	}
+
+	installer, err := dbToInstaller(dbinstaller)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for version, metadata := range installer.VersionMetadata {
+		if (tag != "" && version == tag) || (digest != "" && metadata.PlatformID == name+"@"+digest) {
+			log.Infof("Removing version %s for installer %s", version, name)
+			delete(installer.VersionMetadata, version)
+			removed = true
+		}
+	}
+	if !removed {
+		log.Debugf("No version of installer %s matches tag '%s' or digest '%s'", name, tag, digest)
+		return nil
+	}
+
+	if len(installer.VersionMetadata) == 0 {
+		log.Infof("Installer %s has no versions left. Removing it from the database", name)
+		err = db.DeleteThumbnails(installer.ID)
+		if err != nil {
+			return err
+		}
+		return db.Delete(name)
+	}
+
+	dbinstaller, err = installerToDB(installer)
+	if err != nil {
+		return err
+	}
+	return db.Update(dbinstaller)
+}
+
 // GetAll returns all available installers
 func GetAll() (map[string]Installer, error) {
 	installers := map[string]Installer{}
@@ -153,16 +317,40 @@ func Get(id string) (Installer, error) {
 	return Installer{}, fmt.Errorf("Could not find installer %s", id)
 }
 
-// Search searches the database for all the installers that match the provides field
+// GetByName returns an installer based on its name, and a boolean indicating if it was found
+func GetByName(name string) (Installer, bool, error) {
+	dbinstaller, found, err := db.Get(map[string]interface{}{"name": name})
+	if err != nil || !found {
+		return Installer{}, false, err
+	}
+	installer, err := dbToInstaller(dbinstaller)
+	if err != nil {
+		return Installer{}, false, err
+	}
+	return installer, true, nil
+}
+
+// Search searches the database for all the installers that match the provides field, or the general search string.
+// The provider type can carry a version constraint (e.g. "dns>=2,<3"), in which case only the versions that fulfill
+// it are returned
 func Search(providerType string, general string) (map[string]Installer, error) {
 	var installers map[string]Installer
 	var dbinstallers []db.Installer
 	var err error
 	if providerType != "" {
-		dbinstallers, err = db.SearchProvider(providerType)
+		required, err := ParseRequiredType(providerType)
+		if err != nil {
+			return installers, err
+		}
+		dbinstallers, err = db.SearchProvider(required.Type)
+		if err != nil {
+			return installers, err
+		}
+		installers, err = dbToInstallers(dbinstallers)
 		if err != nil {
 			return installers, err
 		}
+		return FilterRequirement(installers, required), nil
 	} else if general != "" {
 		dbinstallers, err = db.Search(general)
 		if err != nil {
@@ -0,0 +1,235 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/protosio/app-store/util"
+)
+
+// MetadataLabelPrefix is the prefix of all the image labels that hold installer metadata
+const MetadataLabelPrefix = "protos.installer.metadata."
+
+// knownMetadataFields lists all the installer metadata labels, without the label prefix
+var knownMetadataFields = []string{"capabilities", "params", "provides", "requires", "publicports", "description", "thumbnail", "channel"}
+
+// Severity indicates if a metadata problem prevents an installer from being imported
+type Severity string
+
+// Metadata problem severities
+const (
+	SeverityError   = Severity("error")
+	SeverityWarning = Severity("warning")
+)
+
+// Problem is an issue found in the installer metadata labels of an image
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Label    string   `json:"label,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Label == "" {
+		return fmt.Sprintf("%-7s %s", strings.ToUpper(string(p.Severity)), p.Message)
+	}
+	return fmt.Sprintf("%-7s %s: %s", strings.ToUpper(string(p.Severity)), p.Label, p.Message)
+}
+
+// LintReport is the result of checking the installer metadata labels of an image
+type LintReport struct {
+	Valid    bool      `json:"valid"`
+	Problems []Problem `json:"problems"`
+}
+
+// Lint runs all the metadata checks on the provided labels. The metadata is valid if no errors are found
+func Lint(labels map[string]string) LintReport {
+	_, problems := LintMetadata(labels)
+	return LintReport{Valid: ProblemsError(problems) == nil, Problems: problems}
+}
+
+// ProblemsError returns the first error from a list of metadata problems, or nil if there are only warnings
+func ProblemsError(problems []Problem) error {
+	for _, problem := range problems {
+		if problem.Severity == SeverityError {
+			return errors.New(problem.Message)
+		}
+	}
+	return nil
+}
+
+// parsePublicPorts parses a comma separated list of ports (e.g. "80/tcp,53/udp"). Invalid ports are skipped and
+// reported as warnings
+func parsePublicPorts(publicports string) ([]util.Port, []string) {
+	ports := []util.Port{}
+	warnings := []string{}
+	for _, portstr := range strings.Split(publicports, ",") {
+		portParts := strings.Split(portstr, "/")
+		if len(portParts) != 2 {
+			warnings = append(warnings, fmt.Sprintf("Error parsing installer port string '%s' (expected <port>/<protocol>)", portstr))
+			continue
+		}
+		portNr, err := strconv.Atoi(portParts[0])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Error parsing installer port string '%s'", portstr))
+			continue
+		}
+		if portNr < 1 || portNr > 0xffff {
+			warnings = append(warnings, fmt.Sprintf("Installer port is out of range %s (valid range is 1-65535)", portstr))
+			continue
+		}
+		port := util.Port{Nr: portNr}
+		if strings.ToUpper(portParts[1]) == string(util.TCP) {
+			port.Type = util.TCP
+		} else if strings.ToUpper(portParts[1]) == string(util.UDP) {
+			port.Type = util.UDP
+		} else {
+			warnings = append(warnings, fmt.Sprintf("Invalid protocol(%s) for port(%s)", portParts[1], portParts[0]))
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return ports, warnings
+}
+
+// parseList cleans up the entries of a comma separated list, which were split by the caller. Empty entries are
+// skipped and reported as warnings
+func parseList(value string, entries []string) ([]string, []string) {
+	items := []string{}
+	warnings := []string{}
+	for i, item := range entries {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			warnings = append(warnings, fmt.Sprintf("Empty entry at position %d in list '%s'", i+1, value))
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, warnings
+}
+
+// LintMetadata parses the image metadata from the image labels, collecting all the problems found instead of
+// stopping at the first one
+func LintMetadata(labels map[string]string) (InstallerMetadata, []Problem) {
+	metadata := InstallerMetadata{}
+	problems := []Problem{}
+	report := func(severity Severity, label string, messages ...string) {
+		for _, message := range messages {
+			problems = append(problems, Problem{Severity: severity, Label: label, Message: message})
+		}
+	}
+
+	keys := []string{}
+	for label := range labels {
+		keys = append(keys, label)
+	}
+	sort.Strings(keys)
+
+	for _, label := range keys {
+		value := labels[label]
+		if !strings.HasPrefix(label, MetadataLabelPrefix) {
+			continue
+		}
+		var warnings []string
+		switch strings.TrimPrefix(label, MetadataLabelPrefix) {
+		case "capabilities":
+			capabilities, err := ParseCapabilities(value)
+			if err != nil {
+				report(SeverityError, label, err.Error())
+			}
+			metadata.Capabilities = capabilities
+		case "params":
+			params, paramWarnings, err := ParseParams(value)
+			if err != nil {
+				report(SeverityError, label, err.Error())
+			}
+			warnings = paramWarnings
+			metadata.Params = ParamNames(params)
+			metadata.ParamDefinitions = params
+		case "provides":
+			metadata.Provides, warnings = parseList(value, strings.Split(value, ","))
+			for _, entry := range metadata.Provides {
+				if _, err := parseProvidedType(entry); err != nil {
+					warnings = append(warnings, err.Error()+". It is treated as an unversioned provider type")
+				}
+			}
+		case "requires":
+			metadata.Requires, warnings = parseList(value, splitRequires(value))
+			for _, entry := range metadata.Requires {
+				_, err := parseRequiredType(entry)
+				if err == nil {
+					continue
+				}
+				if _, legacyErr := ParseRequiredType(entry); legacyErr != nil {
+					report(SeverityError, label, err.Error())
+				} else {
+					warnings = append(warnings, fmt.Sprintf("Invalid provider type '%s'. It is treated as a provider type without a constraint", entry))
+				}
+			}
+		case "publicports":
+			metadata.PublicPorts, warnings = parsePublicPorts(value)
+		case "description":
+			metadata.Description = value
+		case "channel":
+			err := ValidateChannel(value)
+			if err != nil {
+				report(SeverityError, label, err.Error())
+			} else {
+				metadata.Channel = value
+			}
+		case "thumbnail":
+			if !strings.HasPrefix(value, "data:") && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+				warnings = []string{"Thumbnail should be a data URI or a http(s) URL"}
+			}
+		default:
+			warnings = []string{fmt.Sprintf("Unknown installer metadata label. Known labels are: %s", strings.Join(knownMetadataFields, ", "))}
+		}
+		report(SeverityWarning, label, warnings...)
+	}
+
+	if strings.TrimSpace(metadata.Description) == "" {
+		report(SeverityError, MetadataLabelPrefix+"description", "installer metadata field 'description' is mandatory")
+	}
+	return metadata, problems
+}
+
+// ParseMetadata parses the image metadata from the image labels. Warnings are logged, while the first error found
+// prevents the metadata from being used
+func ParseMetadata(labels map[string]string) (InstallerMetadata, error) {
+	metadata, problems := LintMetadata(labels)
+	err := ProblemsError(problems)
+	if err != nil {
+		return metadata, err
+	}
+	for _, problem := range problems {
+		log.Warnf("Installer metadata problem: %s", problem.String())
+	}
+	return metadata, nil
+}
+
+// PrefixLabels adds the metadata label prefix to the provided fields, if it's missing. This allows metadata files
+// to use the short field names (e.g. "description")
+func PrefixLabels(fields map[string]string) map[string]string {
+	labels := map[string]string{}
+	for field, value := range fields {
+		if !strings.HasPrefix(field, MetadataLabelPrefix) {
+			field = MetadataLabelPrefix + field
+		}
+		labels[field] = value
+	}
+	return labels
+}
+
+// MetadataLabels returns only the installer metadata labels from the provided image labels
+func MetadataLabels(labels map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for label, value := range labels {
+		if strings.HasPrefix(label, MetadataLabelPrefix) {
+			filtered[label] = value
+		}
+	}
+	return filtered
+}
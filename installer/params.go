@@ -0,0 +1,137 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/util"
+)
+
+// ParamType is the type of the value of an installer parameter
+type ParamType string
+
+// Supported parameter types
+const (
+	ParamString = ParamType("string")
+	ParamInt    = ParamType("int")
+	ParamBool   = ParamType("bool")
+)
+
+var paramNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Param describes a parameter that has to be provided when an installer is installed
+type Param struct {
+	Name        string      `json:"name"`
+	Type        ParamType   `json:"type"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required"`
+	Description string      `json:"description,omitempty"`
+	Enum        []string    `json:"enum,omitempty"`
+	Secret      bool        `json:"secret,omitempty"`
+}
+
+// validate checks that the parameter definition is consistent with its type
+func (p *Param) validate() error {
+	if !paramNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid param name '%s'", p.Name)
+	}
+	if p.Type == "" {
+		p.Type = ParamString
+	}
+	if len(p.Enum) > 0 && p.Type != ParamString {
+		return fmt.Errorf("param '%s': enum values are only supported for string params", p.Name)
+	}
+	if p.Secret && p.Type != ParamString {
+		return fmt.Errorf("param '%s': only string params can be secret", p.Name)
+	}
+	if p.Default == nil {
+		return nil
+	}
+
+	switch p.Type {
+	case ParamString:
+		value, ok := p.Default.(string)
+		if !ok {
+			return fmt.Errorf("param '%s': default value must be a string", p.Name)
+		}
+		if found, _ := util.StringInSlice(value, p.Enum); len(p.Enum) > 0 && !found {
+			return fmt.Errorf("param '%s': default value '%s' is not one of %v", p.Name, value, p.Enum)
+		}
+	case ParamInt:
+		value, ok := p.Default.(float64)
+		if !ok || value != math.Trunc(value) {
+			return fmt.Errorf("param '%s': default value must be an integer", p.Name)
+		}
+	case ParamBool:
+		if _, ok := p.Default.(bool); !ok {
+			return fmt.Errorf("param '%s': default value must be a boolean", p.Name)
+		}
+	default:
+		return fmt.Errorf("param '%s': unknown type '%s'", p.Name, p.Type)
+	}
+	return nil
+}
+
+// ParseParams parses the params label. The label is either a JSON array of param definitions, which are strictly
+// validated, or for backwards compatibility a comma separated list of param names, which are treated as string
+// params. Problems with the legacy format are returned as warnings
+func ParseParams(value string) ([]Param, []string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		params, warnings := parseLegacyParams(value)
+		return params, warnings, nil
+	}
+
+	params := []Param{}
+	err := json.Unmarshal([]byte(value), &params)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid params definition")
+	}
+	names := map[string]bool{}
+	for i := range params {
+		err := params[i].validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		if names[params[i].Name] {
+			return nil, nil, fmt.Errorf("param '%s' is defined more than once", params[i].Name)
+		}
+		names[params[i].Name] = true
+	}
+	return params, nil, nil
+}
+
+// parseLegacyParams parses a comma separated list of param names. Invalid and duplicate names are kept as they are
+// and reported as warnings
+func parseLegacyParams(value string) ([]Param, []string) {
+	params := []Param{}
+	warnings := []string{}
+	names := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !paramNameRegexp.MatchString(name) {
+			warnings = append(warnings, fmt.Sprintf("param name '%s' should only contain letters, digits, '_', '.' and '-'", name))
+		}
+		if names[name] {
+			warnings = append(warnings, fmt.Sprintf("param '%s' is defined more than once", name))
+		}
+		names[name] = true
+		params = append(params, Param{Name: name, Type: ParamString})
+	}
+	return params, warnings
+}
+
+// ParamNames returns the names of the provided params, in order
+func ParamNames(params []Param) []string {
+	names := []string{}
+	for _, param := range params {
+		names = append(names, param.Name)
+	}
+	return names
+}
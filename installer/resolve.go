@@ -0,0 +1,310 @@
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProviderFinder returns the installers that fulfill a requirement (e.g. "dns>=2"), with only the versions that
+// should be considered
+type ProviderFinder func(requirement string) (map[string]Installer, error)
+
+// PlanEntry identifies an installer version in an install plan
+type PlanEntry struct {
+	InstallerID string `json:"installerid"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+}
+
+func (e PlanEntry) String() string {
+	return e.Name + "@" + e.Version
+}
+
+// Requirement describes how a required provider type can be satisfied
+type Requirement struct {
+	Type        string      `json:"type"`
+	Constraints []string    `json:"constraints,omitempty"`
+	RequiredBy  []PlanEntry `json:"requiredby"`
+	Candidates  []PlanEntry `json:"candidates"`
+	Recommended *PlanEntry  `json:"recommended,omitempty"`
+}
+
+// InstallPlan lists everything that needs to be installed, in order, for an installer version to work
+type InstallPlan struct {
+	Installer    PlanEntry     `json:"installer"`
+	Requirements []Requirement `json:"requirements"`
+	Order        []PlanEntry   `json:"order"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+// Valid checks if all the requirements of the plan could be satisfied
+func (p InstallPlan) Valid() bool {
+	return len(p.Errors) == 0
+}
+
+// resolver builds an install plan by walking the requirements of the installer versions, depth first
+type resolver struct {
+	findProviders ProviderFinder
+	plan          *InstallPlan
+	// requirements holds the index of each provider type in plan.Requirements
+	requirements map[string]int
+	// selected holds the version chosen for each installer in the plan
+	selected map[string]PlanEntry
+	metadata map[string]InstallerMetadata
+	// dependencies holds the installers each installer in the plan depends on, in the order they are required
+	dependencies map[string][]string
+	// providers holds the installers found for each provider type
+	providers map[string]map[string]Installer
+	// constraints holds, for each provider type, the constraints learned during previous passes that every candidate
+	// has to satisfy. learned is set when a new one is found, and the plan has to be resolved again
+	constraints map[string][]RequiredType
+	learned     bool
+}
+
+// Resolve builds the install plan for a version of an installer. For every required provider type the candidate
+// installer versions are listed and the newest one that satisfies the constraints of all the installers requiring
+// it is recommended, preferring installers that are already part of the plan and then the latest version of each
+// installer. Unsatisfiable and cyclic requirements are reported as errors
+func Resolve(installer Installer, version string, findProviders ProviderFinder) (InstallPlan, error) {
+	metadata, found := installer.VersionMetadata[version]
+	if !found {
+		return InstallPlan{}, fmt.Errorf("Installer %s does not have version %s", installer.ID, version)
+	}
+	root := PlanEntry{InstallerID: installer.ID, Name: installer.Name, Version: version}
+
+	// when a requirement narrows down the candidates of a provider type that was already selected, the plan is
+	// resolved again with the constraint applied from the start. Every pass learns at least one new constraint
+	constraints := map[string][]RequiredType{}
+	for {
+		r := &resolver{
+			findProviders: findProviders,
+			plan:          &InstallPlan{Installer: root, Requirements: []Requirement{}, Order: []PlanEntry{}},
+			requirements:  map[string]int{},
+			selected:      map[string]PlanEntry{root.InstallerID: root},
+			metadata:      map[string]InstallerMetadata{root.InstallerID: metadata},
+			dependencies:  map[string][]string{},
+			providers:     map[string]map[string]Installer{},
+			constraints:   constraints,
+		}
+		err := r.resolve(root)
+		if err != nil {
+			return *r.plan, err
+		}
+		if r.learned {
+			continue
+		}
+		r.order(root.InstallerID)
+		return *r.plan, nil
+	}
+}
+
+// resolve selects a provider for every requirement of an installer version, and then resolves the selected providers
+func (r *resolver) resolve(entry PlanEntry) error {
+	for _, requiredEntry := range r.metadata[entry.InstallerID].Requires {
+		required, err := ParseRequiredType(requiredEntry)
+		if err != nil {
+			r.plan.Errors = append(r.plan.Errors, fmt.Sprintf("Invalid requirement of %s: %s", entry, err.Error()))
+			continue
+		}
+
+		if idx, found := r.requirements[required.Type]; found {
+			requirement := &r.plan.Requirements[idx]
+			requirement.RequiredBy = append(requirement.RequiredBy, entry)
+			if required.Constraint != nil {
+				requirement.Constraints = append(requirement.Constraints, required.constraint)
+			}
+			if requirement.Recommended != nil {
+				recommended := *requirement.Recommended
+				if !r.metadata[recommended.InstallerID].Satisfies(required) {
+					if r.learn(required) {
+						return nil
+					}
+					r.plan.Errors = append(r.plan.Errors, fmt.Sprintf("Conflicting requirements: %s requires %s, but %s was selected for '%s'", entry, required, recommended, required.Type))
+				}
+				r.addDependency(entry.InstallerID, recommended.InstallerID)
+			}
+			continue
+		}
+
+		requirement, providers, err := r.candidates(required)
+		if err != nil {
+			return err
+		}
+		requirement.RequiredBy = []PlanEntry{entry}
+		r.requirements[required.Type] = len(r.plan.Requirements)
+		r.plan.Requirements = append(r.plan.Requirements, requirement)
+		if requirement.Recommended == nil {
+			r.plan.Errors = append(r.plan.Errors, fmt.Sprintf("No installer provides '%s', required by %s", required, entry))
+			continue
+		}
+
+		recommended := *requirement.Recommended
+		r.addDependency(entry.InstallerID, recommended.InstallerID)
+		if selected, found := r.selected[recommended.InstallerID]; found {
+			// an installer can only be part of the plan once, so a different version of it can't be used
+			if selected.Version != recommended.Version {
+				r.plan.Errors = append(r.plan.Errors, fmt.Sprintf("Conflicting requirements: %s requires %s, which is only satisfied by %s, but %s is already part of the plan", entry, required, recommended, selected))
+			}
+			continue
+		}
+		r.selected[recommended.InstallerID] = recommended
+		r.metadata[recommended.InstallerID] = providers[recommended.InstallerID].VersionMetadata[recommended.Version]
+		err = r.resolve(recommended)
+		if err != nil || r.learned {
+			return err
+		}
+	}
+	return nil
+}
+
+// learn records a constraint for a provider type that conflicts with the selected provider, if another candidate
+// satisfies it together with all the other constraints of the type. It returns true if the plan has to be resolved
+// again, because the constraint is new
+func (r *resolver) learn(required RequiredType) bool {
+	for _, known := range r.constraints[required.Type] {
+		if known.String() == required.String() {
+			return false
+		}
+	}
+	requirement := r.plan.Requirements[r.requirements[required.Type]]
+	providers := r.providers[required.Type]
+	for _, candidate := range requirement.Candidates {
+		if r.satisfiesAll(providers[candidate.InstallerID].VersionMetadata[candidate.Version], required, requirement.Constraints) {
+			r.constraints[required.Type] = append(r.constraints[required.Type], required)
+			r.learned = true
+			return true
+		}
+	}
+	return false
+}
+
+// satisfiesAll checks if an installer version provides a type that fulfills a requirement together with all the
+// other constraints on the type
+func (r *resolver) satisfiesAll(metadata InstallerMetadata, required RequiredType, constraints []string) bool {
+	if !metadata.Satisfies(required) {
+		return false
+	}
+	for _, constraint := range constraints {
+		other, err := ParseRequiredType(required.Type + constraint)
+		if err != nil || !metadata.Satisfies(other) {
+			return false
+		}
+	}
+	for _, learned := range r.constraints[required.Type] {
+		if !metadata.Satisfies(learned) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates lists the installer versions that fulfill a requirement and the constraints learned for its type, newest
+// first, and picks the recommended one
+func (r *resolver) candidates(required RequiredType) (Requirement, map[string]Installer, error) {
+	requirement := Requirement{Type: required.Type, Candidates: []PlanEntry{}}
+	if required.Constraint != nil {
+		requirement.Constraints = []string{required.constraint}
+	}
+	providers, err := r.findProviders(required.String())
+	if err != nil {
+		return requirement, nil, err
+	}
+	r.providers[required.Type] = providers
+
+	ids := []string{}
+	for id := range providers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return providers[ids[i]].Name < providers[ids[j]].Name
+	})
+
+	var recommended *PlanEntry
+	for _, id := range ids {
+		provider := providers[id]
+		latest, hasLatest := provider.LatestVersion(true)
+		for _, version := range provider.Versions() {
+			if !r.satisfiesAll(provider.VersionMetadata[version.Tag], required, nil) {
+				continue
+			}
+			candidate := PlanEntry{InstallerID: id, Name: provider.Name, Version: version.Tag}
+			requirement.Candidates = append(requirement.Candidates, candidate)
+
+			// an installer that is already part of the plan is always preferred, to avoid installing more apps
+			if selected, found := r.selected[id]; found {
+				if selected.Version == version.Tag {
+					recommended = &candidate
+				}
+			} else if recommended == nil && (version.Tag == latest || !hasLatest) {
+				recommended = &candidate
+			}
+		}
+	}
+	// the first candidate is used if there is no release of any provider, preferring installers that are not part
+	// of the plan yet, since the plan already holds a different version of the others
+	if recommended == nil {
+		for i, candidate := range requirement.Candidates {
+			if _, found := r.selected[candidate.InstallerID]; !found {
+				recommended = &requirement.Candidates[i]
+				break
+			}
+		}
+	}
+	if recommended == nil && len(requirement.Candidates) > 0 {
+		recommended = &requirement.Candidates[0]
+	}
+	requirement.Recommended = recommended
+	return requirement, providers, nil
+}
+
+func (r *resolver) addDependency(from string, to string) {
+	if from == to {
+		return
+	}
+	for _, dependency := range r.dependencies[from] {
+		if dependency == to {
+			return
+		}
+	}
+	r.dependencies[from] = append(r.dependencies[from], to)
+}
+
+// order computes the install order, dependencies first, using a depth first traversal of the dependency graph.
+// Cycles are reported as errors, with the installers involved
+func (r *resolver) order(root string) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		switch state[id] {
+		case visited:
+			return
+		case visiting:
+			cycle := []string{}
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{r.selected[path[i]].String()}, cycle...)
+				if path[i] == id {
+					break
+				}
+			}
+			cycle = append(cycle, r.selected[id].String())
+			r.plan.Errors = append(r.plan.Errors, "Cyclic requirement: "+strings.Join(cycle, " -> "))
+			return
+		}
+		state[id] = visiting
+		path = append(path, id)
+		for _, dependency := range r.dependencies[id] {
+			visit(dependency)
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		r.plan.Order = append(r.plan.Order, r.selected[id])
+	}
+	visit(root)
+}
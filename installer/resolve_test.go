@@ -0,0 +1,117 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestInstaller(name string, versions map[string]InstallerMetadata) Installer {
+	return Installer{ID: name, Name: name, VersionMetadata: versions}
+}
+
+// testProviderFinder finds providers among the provided installers, keeping only the versions that fulfill the
+// requirement, like Search does
+func testProviderFinder(installers ...Installer) ProviderFinder {
+	return func(requirement string) (map[string]Installer, error) {
+		required, err := ParseRequiredType(requirement)
+		if err != nil {
+			return nil, err
+		}
+		all := map[string]Installer{}
+		for _, installer := range installers {
+			all[installer.ID] = installer
+		}
+		return FilterRequirement(all, required), nil
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dns := newTestInstaller("dns", map[string]InstallerMetadata{
+		"1.0.0": {Provides: []string{"dns@1.0.0"}},
+		"2.0.0": {Provides: []string{"dns@2.0.0"}},
+	})
+	tests := []struct {
+		name       string
+		root       Installer
+		installers []Installer
+		order      []string
+		errors     []string
+	}{
+		{
+			name: "constraint learned from a transitive requirer",
+			root: newTestInstaller("app", map[string]InstallerMetadata{
+				"1.0.0": {Requires: []string{"dns", "mail"}},
+			}),
+			installers: []Installer{dns, newTestInstaller("mail", map[string]InstallerMetadata{
+				"1.0.0": {Provides: []string{"mail@1.0.0"}, Requires: []string{"dns<2"}},
+			})},
+			order: []string{"dns@1.0.0", "mail@1.0.0", "app@1.0.0"},
+		},
+		{
+			name: "two versions of the same installer",
+			root: newTestInstaller("app", map[string]InstallerMetadata{
+				"1.0.0": {Requires: []string{"dns<2", "mail"}},
+			}),
+			installers: []Installer{newTestInstaller("suite", map[string]InstallerMetadata{
+				"1.0.0": {Provides: []string{"dns@1.0.0"}},
+				"2.0.0": {Provides: []string{"dns@2.0.0", "mail@2.0.0"}},
+			})},
+			order:  []string{"suite@1.0.0", "app@1.0.0"},
+			errors: []string{"Conflicting requirements: app@1.0.0 requires mail, which is only satisfied by suite@2.0.0, but suite@1.0.0 is already part of the plan"},
+		},
+		{
+			name: "cycle",
+			root: newTestInstaller("app", map[string]InstallerMetadata{
+				"1.0.0": {Requires: []string{"dns"}},
+			}),
+			installers: []Installer{
+				newTestInstaller("bind", map[string]InstallerMetadata{
+					"1.0.0": {Provides: []string{"dns@1.0.0"}, Requires: []string{"mail"}},
+				}),
+				newTestInstaller("postfix", map[string]InstallerMetadata{
+					"1.0.0": {Provides: []string{"mail@1.0.0"}, Requires: []string{"dns"}},
+				}),
+			},
+			order:  []string{"postfix@1.0.0", "bind@1.0.0", "app@1.0.0"},
+			errors: []string{"Cyclic requirement: bind@1.0.0 -> postfix@1.0.0 -> bind@1.0.0"},
+		},
+		{
+			name: "unsatisfiable requirement",
+			root: newTestInstaller("app", map[string]InstallerMetadata{
+				"1.0.0": {Requires: []string{"dns>=3"}},
+			}),
+			installers: []Installer{dns},
+			order:      []string{"app@1.0.0"},
+			errors:     []string{"No installer provides 'dns>=3', required by app@1.0.0"},
+		},
+		{
+			name: "root provides its own requirement",
+			root: newTestInstaller("app", map[string]InstallerMetadata{
+				"1.0.0": {Provides: []string{"dns@1.0.0"}, Requires: []string{"dns"}},
+			}),
+			installers: []Installer{dns},
+			order:      []string{"app@1.0.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := Resolve(test.root, "1.0.0", testProviderFinder(append(test.installers, test.root)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			order := []string{}
+			for _, entry := range plan.Order {
+				order = append(order, entry.String())
+			}
+			if !reflect.DeepEqual(order, test.order) {
+				t.Errorf("Expected install order %v, got %v", test.order, order)
+			}
+			if !reflect.DeepEqual(plan.Errors, test.errors) {
+				t.Errorf("Expected errors %q, got %q", test.errors, plan.Errors)
+			}
+			if plan.Valid() != (len(test.errors) == 0) {
+				t.Errorf("Expected the plan validity to be %t", len(test.errors) == 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package installer
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Source is a provider of installers, like a Docker registry or a local directory
+type Source interface {
+	// Name identifies the source. It is recorded in the metadata of every version imported from it
+	Name() string
+	// Installers returns the names of all the installers available in the source
+	Installers() ([]string, error)
+	// Versions returns all the versions available for an installer
+	Versions(name string) ([]string, error)
+	// Metadata returns the metadata of a specific installer version
+	Metadata(name string, version string) (InstallerMetadata, error)
+}
+
+// Sync imports all the installer versions available in the provided source. The provided signature, which can be
+// nil, is recorded for every version since installers from a source can't be verified individually. Versions that
+// fail to import are logged and skipped. It returns the number of imported and failed versions
+func Sync(src Source, signature *Signature) (int, int, error) {
+	log.Infof("Importing installers from source '%s'", src.Name())
+	names, err := src.Installers()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "Failed to list installers in source '%s'", src.Name())
+	}
+
+	imported, failed := 0, 0
+	for _, name := range names {
+		versions, err := src.Versions(name)
+		if err != nil {
+			log.Errorf("Failed to retrieve versions for %s: %s", name, err.Error())
+			failed++
+			continue
+		}
+		for _, version := range versions {
+			metadata, err := src.Metadata(name, version)
+			if err != nil {
+				log.Errorf("Could not retrieve metadata for %s(%s): %s", name, version, err.Error())
+				failed++
+				continue
+			}
+			metadata.Source = src.Name()
+			metadata.Signature = signature
+			err = Add(name, version, metadata)
+			if err != nil {
+				log.Errorf("Could not save installer %s(%s): %s", name, version, err.Error())
+				failed++
+				continue
+			}
+			imported++
+		}
+	}
+	log.Infof("Imported %d installer versions from source '%s', %d failed", imported, src.Name(), failed)
+	return imported, failed, nil
+}
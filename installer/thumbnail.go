@@ -0,0 +1,68 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/protosio/app-store/db"
+	"github.com/protosio/app-store/thumbnail"
+)
+
+// ThumbnailURL returns the stable URL at which the thumbnail of an installer is served
+func ThumbnailURL(id string) string {
+	return fmt.Sprintf("/api/v1/installers/%s/thumbnail", id)
+}
+
+// IsThumbnailVersion checks if the thumbnail of an installer should be taken from the provided version, so that
+// importing an older version doesn't replace the thumbnail of a newer one
+func IsThumbnailVersion(name string, version string) (bool, error) {
+	installer, found, err := GetByName(name)
+	if err != nil || !found {
+		return false, err
+	}
+	return installer.ThumbnailVersion() == version, nil
+}
+
+// SetThumbnail validates a thumbnail image, stores all its size variants and points the installer to it. The
+// installer has to exist already
+func SetThumbnail(name string, data []byte) error {
+	defer lockInstaller(name)()
+	dbinstaller, found, err := db.Get(map[string]interface{}{"name": name})
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("Could not find installer %s", name)
+	}
+
+	variants, err := thumbnail.Variants(data)
+	if err != nil {
+		return err
+	}
+	thumbnails := []db.Thumbnail{}
+	for _, variant := range variants {
+		hash := sha256.Sum256(variant.Data)
+		thumbnails = append(thumbnails, db.Thumbnail{
+			Size:        variant.Size,
+			ContentType: variant.ContentType,
+			ETag:        "\"" + hex.EncodeToString(hash[:]) + "\"",
+			Data:        variant.Data,
+		})
+	}
+	err = db.SaveThumbnails(dbinstaller.ID, thumbnails)
+	if err != nil {
+		return err
+	}
+
+	if dbinstaller.Thumbnail != ThumbnailURL(dbinstaller.ID) {
+		log.Infof("Setting thumbnail for installer %s", name)
+		dbinstaller.Thumbnail = ThumbnailURL(dbinstaller.ID)
+		return db.Update(dbinstaller)
+	}
+	return nil
+}
+
+// GetThumbnail returns a size variant of the thumbnail of an installer, and a boolean indicating if it was found
+func GetThumbnail(id string, size string) (db.Thumbnail, bool, error) {
+	return db.GetThumbnail(id, size)
+}
@@ -0,0 +1,110 @@
+package installer
+
+import (
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// VersionKind classifies installer version tags
+type VersionKind string
+
+// Installer version kinds. Tags that are not semantic versions (e.g. "latest" or "dev") are classified as other
+const (
+	VersionRelease    = VersionKind("release")
+	VersionPrerelease = VersionKind("prerelease")
+	VersionOther      = VersionKind("other")
+)
+
+// Version is an installer version tag, classified based on its semantic version
+type Version struct {
+	Tag  string      `json:"tag"`
+	Kind VersionKind `json:"kind"`
+
+	semver *semver.Version
+}
+
+// ParseVersion parses an installer version tag as a semantic version. A leading "v" and missing minor or patch
+// numbers are accepted (e.g. "v1.2")
+func ParseVersion(tag string) Version {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return Version{Tag: tag, Kind: VersionOther}
+	}
+	if v.Prerelease() != "" {
+		return Version{Tag: tag, Kind: VersionPrerelease, semver: v}
+	}
+	return Version{Tag: tag, Kind: VersionRelease, semver: v}
+}
+
+// Semver returns the semantic version of the tag, or nil if the tag is not a semantic version
+func (v Version) Semver() *semver.Version {
+	return v.semver
+}
+
+// less orders versions from newest to oldest. Semantic versions come first, followed by the other tags in
+// alphabetical order
+func (v Version) less(other Version) bool {
+	if v.semver == nil || other.semver == nil {
+		if v.semver != nil || other.semver != nil {
+			return v.semver != nil
+		}
+		return v.Tag < other.Tag
+	}
+	if v.semver.Equal(other.semver) {
+		// "1.0" and "1.0.0" are the same version, so the tags are compared to keep the order stable
+		return v.Tag < other.Tag
+	}
+	return v.semver.GreaterThan(other.semver)
+}
+
+// SortVersions parses the provided tags and orders them from newest to oldest
+func SortVersions(tags []string) []Version {
+	versions := []Version{}
+	for _, tag := range tags {
+		versions = append(versions, ParseVersion(tag))
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].less(versions[j])
+	})
+	return versions
+}
+
+// Versions returns the versions of an installer, from newest to oldest
+func (i Installer) Versions() []Version {
+	tags := []string{}
+	for tag := range i.VersionMetadata {
+		tags = append(tags, tag)
+	}
+	return SortVersions(tags)
+}
+
+// LatestVersion returns the newest release of an installer. Pre-releases are only considered if prerelease is set
+func (i Installer) LatestVersion(prerelease bool) (string, bool) {
+	for _, version := range i.Versions() {
+		if version.Kind == VersionRelease || (prerelease && version.Kind == VersionPrerelease) {
+			return version.Tag, true
+		}
+	}
+	return "", false
+}
+
+// WithVersionInfo fills in the sorted versions and the latest version of an installer
+func (i Installer) WithVersionInfo(prerelease bool) Installer {
+	i.SortedVersions = i.Versions()
+	i.Latest, _ = i.LatestVersion(prerelease)
+	return i
+}
+
+// ThumbnailVersion returns the version the installer thumbnail is taken from: the newest release, or the newest
+// version if the installer has no releases
+func (i Installer) ThumbnailVersion() string {
+	if latest, found := i.LatestVersion(false); found {
+		return latest
+	}
+	versions := i.Versions()
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[0].Tag
+}
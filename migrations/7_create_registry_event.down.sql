@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE registry_event;
+END;
\ No newline at end of file
@@ -0,0 +1,15 @@
+BEGIN;
+CREATE TABLE registry_event (
+	id              varchar NOT NULL PRIMARY KEY,
+	action          varchar NOT NULL,
+	repository      varchar NOT NULL,
+	tag             varchar NOT NULL DEFAULT '',
+	payload         jsonb NOT NULL,
+	status          varchar NOT NULL DEFAULT 'pending',
+	attempts        integer NOT NULL DEFAULT 0,
+	last_error      text NOT NULL DEFAULT '',
+	received_at     timestamptz NOT NULL DEFAULT now(),
+	next_attempt_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX registry_event_status_idx ON registry_event (status, next_attempt_at);
+END;
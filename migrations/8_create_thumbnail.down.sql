@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE installer_thumbnail;
+END;
\ No newline at end of file
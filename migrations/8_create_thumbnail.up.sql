@@ -0,0 +1,10 @@
+BEGIN;
+CREATE TABLE installer_thumbnail (
+	installer_id varchar NOT NULL,
+	size         varchar NOT NULL,
+	content_type varchar NOT NULL,
+	etag         varchar NOT NULL,
+	data         bytea NOT NULL,
+	PRIMARY KEY (installer_id, size)
+);
+END;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE quarantined_image;
+END;
\ No newline at end of file
@@ -0,0 +1,12 @@
+BEGIN;
+CREATE TABLE quarantined_image (
+	repository      varchar NOT NULL,
+	tag             varchar NOT NULL,
+	digest          varchar NOT NULL DEFAULT '',
+	problems        jsonb NOT NULL,
+	attempts        integer NOT NULL DEFAULT 1,
+	first_failed_at timestamptz NOT NULL DEFAULT now(),
+	last_failed_at  timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (repository, tag)
+);
+END;
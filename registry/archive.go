@@ -0,0 +1,417 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/installer"
+)
+
+// ImportSourceName identifies the installer versions imported from image archives without pushing them to the registry
+const ImportSourceName = "import"
+
+// containerdImageNameAnnotation holds the full image reference in OCI layouts written by `docker save`
+const containerdImageNameAnnotation = "io.containerd.image.name"
+
+// dockerManifestEntry is an entry of the manifest.json file written by `docker save`
+type dockerManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Archive is a single image read from a `docker save` tarball or an OCI image layout (directory or tarball)
+type Archive struct {
+	Name   string
+	Tag    string
+	Digest digest.Digest
+
+	manifest  []byte
+	mediaType string
+	config    imageConfig
+	blobs     map[digest.Digest]string
+	tmpDir    string
+}
+
+// OpenArchive reads the image stored at path. If the archive contains more than one image, name and tag select
+// the image to use. They also override the name and tag recorded in the archive
+func OpenArchive(path string, name string, tag string) (*Archive, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{blobs: map[digest.Digest]string{}}
+	dir := path
+	if !info.IsDir() {
+		archive.tmpDir, err = ioutil.TempDir("", "app-store-import")
+		if err != nil {
+			return nil, err
+		}
+		err = extractTar(path, archive.tmpDir)
+		if err != nil {
+			archive.Close()
+			return nil, errors.Wrapf(err, "Failed to extract %s", path)
+		}
+		dir = archive.tmpDir
+	}
+
+	// tarballs written by recent Docker versions contain both formats, in which case the OCI layout is preferred
+	// because it holds the real manifest digests
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		err = archive.loadOCILayout(dir, name, tag)
+	} else if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+		err = archive.loadDockerSave(dir, name, tag)
+	} else {
+		err = fmt.Errorf("%s is neither an OCI image layout nor a docker save archive", path)
+	}
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	if name != "" {
+		archive.Name = name
+	}
+	if tag != "" {
+		archive.Tag = tag
+	}
+	if archive.Name == "" || archive.Tag == "" {
+		archive.Close()
+		return nil, errors.New("Could not determine the image name and tag from the archive. Please provide them")
+	}
+	return archive, nil
+}
+
+// Close removes the temporary files created while reading the archive
+func (a *Archive) Close() {
+	if a.tmpDir != "" {
+		os.RemoveAll(a.tmpDir)
+	}
+}
+
+// Metadata parses the installer metadata from the image labels, using the archive manifest digest as platform id
+func (a *Archive) Metadata() (installer.InstallerMetadata, error) {
+	metadata, err := installer.ParseMetadata(a.config.Config.Labels)
+	if err != nil {
+		return metadata, errors.Wrap(err, "Could not parse metadata for image")
+	}
+	manifest, err := decodeManifest(a.manifest, a.mediaType)
+	if err != nil {
+		return metadata, err
+	}
+	platform := installer.Platform{
+		OS:           a.config.OS,
+		Architecture: a.config.Architecture,
+		Variant:      a.config.Variant,
+		Digest:       a.Digest.String(),
+		Size:         imageSize(manifest),
+		Labels:       installer.MetadataLabels(a.config.Config.Labels),
+	}
+	metadata.PlatformID = a.Name + "@" + a.Digest.String()
+	metadata.Platforms = map[string]installer.Platform{platform.Key(): platform}
+	metadata.Image = imageInfo(manifest, a.config, manifest.Annotations)
+	metadata.Source = ImportSourceName
+	return metadata, nil
+}
+
+// splitReference splits an image reference into a repository name and a tag. The registry host, if any, is
+// removed because installer names don't include it
+func splitReference(ref string) (string, string) {
+	name, tag := ref, ""
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		name, tag = ref[:idx], ref[idx+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		name = parts[1]
+	}
+	return name, tag
+}
+
+func (a *Archive) loadConfig(path string) error {
+	configJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(configJSON, &a.config)
+	if err != nil {
+		return errors.Wrap(err, "Error unmarshalling image config")
+	}
+	return nil
+}
+
+// loadOCILayout reads an image from an OCI image layout. If the selected image is an index, the manifest for the
+// current platform is used
+func (a *Archive) loadOCILayout(dir string, name string, tag string) error {
+	blobPath := func(d digest.Digest) string {
+		return filepath.Join(dir, "blobs", d.Algorithm().String(), d.Hex())
+	}
+
+	indexJSON, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return err
+	}
+	var index imageIndex
+	err = json.Unmarshal(indexJSON, &index)
+	if err != nil {
+		return errors.Wrap(err, "Error unmarshalling OCI layout index")
+	}
+
+	var selected *ispec.Descriptor
+	for i, desc := range index.Manifests {
+		refName, refTag := splitReference(desc.Annotations[containerdImageNameAnnotation])
+		if refTag == "" {
+			refTag = desc.Annotations[ispec.AnnotationRefName]
+		}
+		if (name != "" && refName != "" && refName != name) || (tag != "" && refTag != tag) {
+			continue
+		}
+		if selected != nil {
+			return errors.New("The OCI layout contains multiple images. Please select one using the name and tag")
+		}
+		selected = &index.Manifests[i]
+		a.Name, a.Tag = refName, refTag
+	}
+	if selected == nil {
+		return errors.New("No matching image found in the OCI layout")
+	}
+
+	desc := *selected
+	if isIndex(desc.MediaType) {
+		nestedJSON, err := ioutil.ReadFile(blobPath(desc.Digest))
+		if err != nil {
+			return err
+		}
+		var nested imageIndex
+		err = json.Unmarshal(nestedJSON, &nested)
+		if err != nil {
+			return errors.Wrap(err, "Error unmarshalling image index")
+		}
+		desc, err = selectPlatform(nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.manifest, err = ioutil.ReadFile(blobPath(desc.Digest))
+	if err != nil {
+		return err
+	}
+	a.mediaType = manifestMediaType(desc.MediaType, a.manifest)
+	a.Digest = desc.Digest
+	manifest, err := decodeManifest(a.manifest, a.mediaType)
+	if err != nil {
+		return err
+	}
+	for _, blob := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		a.blobs[blob.Digest] = blobPath(blob.Digest)
+	}
+	return a.loadConfig(blobPath(manifest.Config.Digest))
+}
+
+// loadDockerSave reads an image from a `docker save` archive. Such archives don't contain a registry manifest, so
+// an OCI manifest referencing the uncompressed layers is generated, and its digest is used for the image
+func (a *Archive) loadDockerSave(dir string, name string, tag string) error {
+	manifestJSON, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	var entries []dockerManifestEntry
+	err = json.Unmarshal(manifestJSON, &entries)
+	if err != nil {
+		return errors.Wrap(err, "Error unmarshalling docker save manifest")
+	}
+
+	var selected *dockerManifestEntry
+	for i, entry := range entries {
+		match := len(entries) == 1
+		for _, repoTag := range entry.RepoTags {
+			refName, refTag := splitReference(repoTag)
+			if (name == "" || refName == name) && (tag == "" || refTag == tag) {
+				a.Name, a.Tag = refName, refTag
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if selected != nil {
+			return errors.New("The archive contains multiple images. Please select one using the name and tag")
+		}
+		selected = &entries[i]
+	}
+	if selected == nil {
+		return errors.New("No matching image found in the archive")
+	}
+
+	configDesc, err := describeFile(filepath.Join(dir, selected.Config), ispec.MediaTypeImageConfig)
+	if err != nil {
+		return err
+	}
+	a.blobs[configDesc.Digest] = filepath.Join(dir, selected.Config)
+	manifest := imageManifest{SchemaVersion: 2, MediaType: ispec.MediaTypeImageManifest, Config: configDesc, Layers: []ispec.Descriptor{}}
+	for _, layer := range selected.Layers {
+		layerDesc, err := describeFile(filepath.Join(dir, layer), ispec.MediaTypeImageLayer)
+		if err != nil {
+			return err
+		}
+		a.blobs[layerDesc.Digest] = filepath.Join(dir, layer)
+		manifest.Layers = append(manifest.Layers, layerDesc)
+	}
+
+	a.manifest, err = json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	a.mediaType = ispec.MediaTypeImageManifest
+	a.Digest = digest.FromBytes(a.manifest)
+	return a.loadConfig(filepath.Join(dir, selected.Config))
+}
+
+// describeFile computes the descriptor of a file that will be uploaded as a blob
+func describeFile(path string, mediaType string) (ispec.Descriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+	defer f.Close()
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+	return ispec.Descriptor{MediaType: mediaType, Digest: digester.Digest(), Size: size}, nil
+}
+
+// extractTar extracts a tarball, optionally gzip compressed, into dir. Entries that would end up outside of dir
+// are rejected. Symbolic links, which docker save uses for layers shared between images, are replaced by the files
+// they point to once everything else is extracted
+func extractTar(path string, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = bufio.NewReader(f)
+	if magic, err := reader.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	links := map[string]string{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return extractLinks(dir, links)
+		} else if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.Clean("/"+header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr)
+		case tar.TypeSymlink:
+			links[target], err = linkTarget(dir, target, header.Linkname)
+		default:
+			log.Debugf("Ignoring archive entry %s of type %c", header.Name, header.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// linkTarget resolves the target of a symbolic link extracted at path, making sure it stays inside dir
+func linkTarget(dir string, path string, linkname string) (string, error) {
+	target := filepath.Join(dir, filepath.Clean("/"+linkname))
+	if !filepath.IsAbs(linkname) {
+		target = filepath.Join(filepath.Dir(path), linkname)
+	}
+	if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("Archive entry %s links outside of the archive", strings.TrimPrefix(path, dir))
+	}
+	return target, nil
+}
+
+// extractLinks replaces the symbolic links of an archive with the files they point to. Links can point to other
+// links, so they are resolved in as many passes as needed
+func extractLinks(dir string, links map[string]string) error {
+	for len(links) > 0 {
+		resolved := 0
+		for path, target := range links {
+			if _, pending := links[target]; pending {
+				continue
+			}
+			if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+				log.Debugf("Ignoring archive link %s, which does not point to a file", strings.TrimPrefix(path, dir))
+				delete(links, path)
+				resolved++
+				continue
+			}
+			err := copyFile(target, path)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to extract archive link %s", strings.TrimPrefix(path, dir))
+			}
+			delete(links, path)
+			resolved++
+		}
+		if resolved == 0 {
+			return errors.New("Archive contains a symbolic link loop")
+		}
+	}
+	return nil
+}
+
+// copyFile makes a regular file available at path, using a hard link when possible
+func copyFile(src string, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	if os.Link(src, path) == nil {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(path, f)
+}
+
+func writeFile(path string, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultTokenExpiration is used when the token server does not specify an expiration, as defined by the
+	// Docker token authentication specification
+	defaultTokenExpiration = 60 * time.Second
+	// tokenExpirationLeeway makes sure a token is refreshed slightly before it actually expires
+	tokenExpirationLeeway = 5 * time.Second
+)
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+// tokenCache holds the bearer tokens obtained from the token server, indexed by the scope of the requests they were
+// obtained for
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]bearerToken
+}
+
+func (tc *tokenCache) get(scope string) (string, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	token, found := tc.tokens[scope]
+	if !found || time.Now().After(token.expires) {
+		return "", false
+	}
+	return token.token, true
+}
+
+func (tc *tokenCache) set(scope string, token string, expires time.Time) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.tokens == nil {
+		tc.tokens = map[string]bearerToken{}
+	}
+	tc.tokens[scope] = bearerToken{token: token, expires: expires}
+}
+
+// tokenResponse is the body returned by a Docker registry token server
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// scopeForRequest returns the token scope required for a registry API request. Requests that don't only read from
+// a repository also need the push action
+func scopeForRequest(method string, path string) string {
+	path = strings.TrimPrefix(path, "/v2/")
+	if path == "_catalog" {
+		return "registry:catalog:*"
+	}
+	actions := "pull"
+	if method != "GET" && method != "HEAD" {
+		actions = "pull,push"
+	}
+	for _, endpoint := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if idx := strings.LastIndex(path, endpoint); idx > 0 {
+			return fmt.Sprintf("repository:%s:%s", path[:idx], actions)
+		}
+	}
+	return ""
+}
+
+// fetchToken retrieves a bearer token from the token server described by the provided challenge parameters. It
+// returns the token and the time it should no longer be used
+func (c *Client) fetchToken(params map[string]string) (string, time.Time, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", time.Time{}, errors.New("Bearer challenge does not contain a realm")
+	}
+	scope := params["scope"]
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "Invalid token realm '%s'", realm)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", tokenURL.String(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	log.Debugf("Requesting registry token for scope '%s' from %s", scope, realm)
+	r, err := c.httpClient.Do(req)
+	received := time.Now()
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to retrieve registry token")
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("Token server %s returned %d", realm, r.StatusCode)
+	}
+
+	bodyJSON, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to read token response")
+	}
+	var tr tokenResponse
+	err = json.Unmarshal(bodyJSON, &tr)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Error unmarshalling token response")
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	if token == "" {
+		return "", time.Time{}, errors.New("Token server returned an empty token")
+	}
+
+	// the expiration is relative to when the token was received, since the clock of the token server can't be trusted
+	expiresIn := defaultTokenExpiration
+	if tr.ExpiresIn > 0 {
+		expiresIn = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return token, received.Add(expiresIn - tokenExpirationLeeway), nil
+}
+
+// do performs a request against the registry. If the registry responds with an authentication challenge, the
+// request is retried once using either basic auth or a bearer token obtained from the token server
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	scope := scopeForRequest(req.Method, req.URL.Path)
+	if token, found := c.tokens.get(scope); found {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r, err := c.httpClient.Do(req)
+	if err != nil || r.StatusCode != http.StatusUnauthorized {
+		return r, err
+	}
+
+	challenges := challenge.ResponseChallenges(r)
+	if len(challenges) == 0 {
+		return r, nil
+	}
+	r.Body.Close()
+
+	retry, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range challenges {
+		switch strings.ToLower(ch.Scheme) {
+		case "bearer":
+			token, expires, err := c.fetchToken(ch.Parameters)
+			if err != nil {
+				return nil, err
+			}
+			// the token is cached under the scope of the request, so the next request for the same scope finds it
+			c.tokens.set(scope, token, expires)
+			retry.Header.Set("Authorization", "Bearer "+token)
+			return c.httpClient.Do(retry)
+		case "basic":
+			if c.username == "" {
+				return nil, errors.New("Registry requires basic authentication but no credentials are configured")
+			}
+			retry.SetBasicAuth(c.username, c.password)
+			return c.httpClient.Do(retry)
+		}
+	}
+	return nil, fmt.Errorf("Unsupported registry authentication challenge '%s'", challenges[0].Scheme)
+}
+
+// cloneRequest copies a request so it can be sent again, rewinding its body if it has one
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("Request body can't be replayed for authentication")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
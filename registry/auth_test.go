@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	testUser  = "user"
+	testPass  = "pass"
+	testToken = "secret-token"
+)
+
+// fakeTokenServer issues testToken to clients using the test credentials, and counts the tokens it issued
+type fakeTokenServer struct {
+	*httptest.Server
+	issued    int32
+	expiresIn int
+	scopes    []string
+}
+
+func newFakeTokenServer(t *testing.T, expiresIn int) *fakeTokenServer {
+	ts := &fakeTokenServer{expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != testUser || pass != testPass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if service := r.URL.Query().Get("service"); service != "registry" {
+			t.Errorf("Token requested for service '%s'", service)
+		}
+		atomic.AddInt32(&ts.issued, 1)
+		ts.scopes = append(ts.scopes, r.URL.Query().Get("scope"))
+		// issued_at is far in the past to make sure the expiration is based on the local clock
+		fmt.Fprintf(w, `{"token": %q, "expires_in": %d, "issued_at": "2000-01-01T00:00:00Z"}`, testToken, ts.expiresIn)
+	}))
+	return ts
+}
+
+// newBearerRegistry starts a registry that requires testToken, and sends a bearer challenge with the provided scope
+// otherwise
+func newBearerRegistry(realm string, scope string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			challenge := fmt.Sprintf(`Bearer realm="%s",service="registry"`, realm)
+			if scope != "" {
+				challenge += fmt.Sprintf(`,scope="%s"`, scope)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func newTestClient(baseURL string, username string, password string) *Client {
+	return &Client{baseURL: baseURL, httpClient: http.DefaultClient, username: username, password: password}
+}
+
+func request(t *testing.T, c *Client, method string, path string) int {
+	req, err := http.NewRequest(method, c.url(path), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %s", method, path, err.Error())
+	}
+	r.Body.Close()
+	return r.StatusCode
+}
+
+func TestBearerChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		scope  string
+	}{
+		{"pull", "GET", "/v2/apps/dns/manifests/1.0", "repository:apps/dns:pull"},
+		{"push", "POST", "/v2/apps/dns/blobs/uploads/", "repository:apps/dns:pull,push"},
+		{"catalog", "GET", "/v2/_catalog", "registry:catalog:*"},
+		{"no scope", "GET", "/v2/apps/dns/tags/list", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newFakeTokenServer(t, 300)
+			defer tokenServer.Close()
+			reg := newBearerRegistry(tokenServer.URL, test.scope)
+			defer reg.Close()
+			c := newTestClient(reg.URL, testUser, testPass)
+
+			for i := 0; i < 3; i++ {
+				if status := request(t, c, test.method, test.path); status != http.StatusOK {
+					t.Fatalf("Request %d returned %d", i, status)
+				}
+			}
+			if tokenServer.issued != 1 {
+				t.Errorf("Expected the token to be cached, but %d tokens were issued", tokenServer.issued)
+			}
+			if tokenServer.scopes[0] != test.scope {
+				t.Errorf("Token requested for scope '%s' instead of '%s'", tokenServer.scopes[0], test.scope)
+			}
+		})
+	}
+}
+
+func TestBearerTokenScopes(t *testing.T) {
+	tokenServer := newFakeTokenServer(t, 300)
+	defer tokenServer.Close()
+	reg := newBearerRegistry(tokenServer.URL, "")
+	defer reg.Close()
+	c := newTestClient(reg.URL, testUser, testPass)
+
+	paths := []string{"/v2/apps/dns/manifests/1.0", "/v2/apps/mail/manifests/1.0", "/v2/apps/dns/blobs/sha256:abc"}
+	for _, path := range paths {
+		if status := request(t, c, "GET", path); status != http.StatusOK {
+			t.Fatalf("GET %s returned %d", path, status)
+		}
+	}
+	// the manifest and the blob of the same repository share a token
+	if tokenServer.issued != 2 {
+		t.Errorf("Expected 2 tokens to be issued, got %d", tokenServer.issued)
+	}
+}
+
+func TestBearerTokenExpiry(t *testing.T) {
+	// tokens that expire within the expiration leeway are never reused
+	tokenServer := newFakeTokenServer(t, 1)
+	defer tokenServer.Close()
+	reg := newBearerRegistry(tokenServer.URL, "repository:apps/dns:pull")
+	defer reg.Close()
+	c := newTestClient(reg.URL, testUser, testPass)
+
+	for i := 0; i < 2; i++ {
+		if status := request(t, c, "GET", "/v2/apps/dns/manifests/1.0"); status != http.StatusOK {
+			t.Fatalf("Request %d returned %d", i, status)
+		}
+	}
+	if tokenServer.issued != 2 {
+		t.Errorf("Expected an expired token to be refreshed, but %d tokens were issued", tokenServer.issued)
+	}
+}
+
+func TestBearerTokenServerRejectsCredentials(t *testing.T) {
+	tokenServer := newFakeTokenServer(t, 300)
+	defer tokenServer.Close()
+	reg := newBearerRegistry(tokenServer.URL, "repository:apps/dns:pull")
+	defer reg.Close()
+	c := newTestClient(reg.URL, testUser, "wrong")
+
+	req, _ := http.NewRequest("GET", c.url("/v2/apps/dns/manifests/1.0"), nil)
+	if _, err := c.do(req); err == nil {
+		t.Error("Expected an error when the token server rejects the credentials")
+	}
+}
+
+func TestBasicChallenge(t *testing.T) {
+	var unauthorized int32
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != testUser || pass != testPass {
+			atomic.AddInt32(&unauthorized, 1)
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer reg.Close()
+
+	c := newTestClient(reg.URL, testUser, testPass)
+	if status := request(t, c, "GET", "/v2/apps/dns/manifests/1.0"); status != http.StatusOK {
+		t.Fatalf("Request returned %d", status)
+	}
+	if unauthorized != 1 {
+		t.Errorf("Expected a single challenge, got %d", unauthorized)
+	}
+
+	c = newTestClient(reg.URL, "", "")
+	req, _ := http.NewRequest("GET", c.url("/v2/apps/dns/manifests/1.0"), nil)
+	if _, err := c.do(req); err == nil {
+		t.Error("Expected an error when basic auth is required without credentials")
+	}
+}
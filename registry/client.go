@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/util"
+)
+
+// Client talks to a Docker v2 registry
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	username     string
+	password     string
+	tokens       tokenCache
+	pageSize     int
+	scanWorkers  int
+	eventsQueued chan struct{}
+	reconciler   *reconciler
+	verifier     *verifier
+}
+
+// NewClient creates a registry client based on the registry settings in the provided config
+func NewClient(cfg *util.Config) (*Client, error) {
+	if cfg.RegistryScheme != "http" && cfg.RegistryScheme != "https" {
+		return nil, fmt.Errorf("Invalid registry scheme '%s'. Only http and https are supported", cfg.RegistryScheme)
+	}
+	if cfg.RegistryPageSize < 1 {
+		return nil, fmt.Errorf("Invalid registry page size %d", cfg.RegistryPageSize)
+	}
+	if cfg.ScanConcurrency < 1 {
+		return nil, fmt.Errorf("Invalid scan concurrency %d", cfg.ScanConcurrency)
+	}
+	if cfg.RegistryHost == "" {
+		return nil, errors.New("Registry host can't be empty")
+	}
+
+	verifier, err := newVerifier(cfg.SignaturePolicy, cfg.SignatureKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.RegistryCA != "" {
+		if cfg.RegistryScheme != "https" {
+			return nil, errors.New("A registry CA certificate can only be used with the https scheme")
+		}
+		caPEM, err := ioutil.ReadFile(cfg.RegistryCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read registry CA certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("No valid certificates found in %s", cfg.RegistryCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client := &Client{
+		baseURL:      fmt.Sprintf("%s://%s:%d", cfg.RegistryScheme, cfg.RegistryHost, cfg.RegistryPort),
+		httpClient:   &http.Client{Transport: transport, Timeout: 60 * time.Second},
+		username:     cfg.RegistryUser,
+		password:     cfg.RegistryPass,
+		pageSize:     cfg.RegistryPageSize,
+		scanWorkers:  cfg.ScanConcurrency,
+		eventsQueued: make(chan struct{}, 1),
+		verifier:     verifier,
+	}
+	log.Debugf("Using Docker registry at %s", client.baseURL)
+	return client, nil
+}
+
+// url returns the absolute registry URL for the provided path
+func (c *Client) url(format string, a ...interface{}) string {
+	return c.baseURL + fmt.Sprintf(format, a...)
+}
+
+// get performs a GET request against the registry, optionally setting the Accept header
+func (c *Client) get(url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return c.do(req)
+}
+
+// head performs a HEAD request against the registry, optionally setting the Accept header
+func (c *Client) head(url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return c.do(req)
+}
+
+// fetchReady performs a GET request and returns the response body and headers. A 404 or a transport error is
+// reported as retryable because the registry might not have finished processing the upload yet
+func (c *Client) fetchReady(url string, accept string) ([]byte, http.Header, bool, error) {
+	r, err := c.get(url, accept)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return nil, nil, true, errors.Wrapf(ErrNotFound, "%s returned %d", url, r.StatusCode)
+	} else if r.StatusCode != http.StatusOK {
+		return nil, nil, false, fmt.Errorf("%s returned %d", url, r.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	return body, r.Header, false, nil
+}
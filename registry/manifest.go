@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/installer"
+)
+
+// manifestAccept lists all the manifest media types the app store understands, and is sent as the Accept header
+// when retrieving manifests. It must be the same for HEAD and GET requests, otherwise the digests won't match
+var manifestAccept = strings.Join([]string{
+	schema2.MediaTypeManifest,
+	manifestlist.MediaTypeManifestList,
+	ispec.MediaTypeImageManifest,
+	ispec.MediaTypeImageIndex,
+}, ", ")
+
+// imageManifest covers both the Docker schema2 and the OCI image manifest, which share the same layout
+type imageManifest struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType,omitempty"`
+	Config        ispec.Descriptor   `json:"config"`
+	Layers        []ispec.Descriptor `json:"layers"`
+	Annotations   map[string]string  `json:"annotations,omitempty"`
+}
+
+// imageIndex covers both the Docker manifest list and the OCI image index, which share the same layout
+type imageIndex struct {
+	MediaType   string             `json:"mediaType"`
+	Manifests   []ispec.Descriptor `json:"manifests"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+}
+
+// manifestMediaType determines the media type of a manifest, based on the Content-Type header and falling back to
+// the mediaType field of the manifest itself
+func manifestMediaType(contentType string, body []byte) string {
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil && mediaType != "application/json" && mediaType != "text/plain" {
+			return mediaType
+		}
+	}
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+		Manifests []json.RawMessage
+	}
+	if json.Unmarshal(body, &versioned) == nil {
+		if versioned.MediaType != "" {
+			return versioned.MediaType
+		}
+		// OCI manifests and indexes are not required to contain the mediaType field
+		if versioned.Manifests != nil {
+			return ispec.MediaTypeImageIndex
+		}
+	}
+	return ispec.MediaTypeImageManifest
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == manifestlist.MediaTypeManifestList || mediaType == ispec.MediaTypeImageIndex
+}
+
+func isManifest(mediaType string) bool {
+	return mediaType == schema2.MediaTypeManifest || mediaType == ispec.MediaTypeImageManifest
+}
+
+// isPlatformManifest checks if an index entry is an image manifest for a real platform. Build attestations are
+// stored in indexes as manifests with an "unknown" platform and are skipped
+func isPlatformManifest(desc ispec.Descriptor) bool {
+	return isManifest(desc.MediaType) && desc.Platform != nil && desc.Platform.OS != "unknown" && desc.Platform.Architecture != "unknown"
+}
+
+// selectPlatform picks the manifest from an index that matches the platform the app store runs on, falling back
+// to the first image manifest in the index
+func selectPlatform(index imageIndex) (ispec.Descriptor, error) {
+	var fallback *ispec.Descriptor
+	for i, desc := range index.Manifests {
+		if !isPlatformManifest(desc) {
+			continue
+		}
+		if desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+		if fallback == nil {
+			fallback = &index.Manifests[i]
+		}
+	}
+	if fallback == nil {
+		return ispec.Descriptor{}, errors.New("Image index does not contain any image manifests")
+	}
+	return *fallback, nil
+}
+
+// fetchManifest retrieves a manifest by tag or digest, polling until it is available or the timeout expires. It
+// returns the manifest body, its media type and its digest
+func (c *Client) fetchManifest(name string, reference string, timeout time.Duration) ([]byte, string, string, error) {
+	var body []byte
+	var mediaType, digest string
+	url := c.url("/v2/%s/manifests/%s", name, reference)
+	err := pollWithBackoff(timeout, func() (bool, error) {
+		bodyJSON, headers, retry, err := c.fetchReady(url, manifestAccept)
+		if err != nil {
+			return retry, err
+		}
+		digest = headers.Get("docker-content-digest")
+		if digest == "" {
+			return false, errors.New("The image digest is empty")
+		}
+		body = bodyJSON
+		mediaType = manifestMediaType(headers.Get("Content-Type"), bodyJSON)
+		return false, nil
+	})
+	return body, mediaType, digest, err
+}
+
+// imageConfig holds the fields of the image config blob used by the app store. The Docker and OCI config formats
+// are compatible for these fields
+type imageConfig struct {
+	Architecture string     `json:"architecture"`
+	OS           string     `json:"os"`
+	Variant      string     `json:"variant"`
+	Created      *time.Time `json:"created,omitempty"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// fetchConfig retrieves and decodes the config blob referenced by an image manifest, polling until it is available
+// or the timeout expires
+func (c *Client) fetchConfig(name string, manifest imageManifest, timeout time.Duration) (imageConfig, error) {
+	var config imageConfig
+	url := c.url("/v2/%s/blobs/%s", name, manifest.Config.Digest.String())
+	err := pollWithBackoff(timeout, func() (bool, error) {
+		bodyJSON, _, retry, err := c.fetchReady(url, "")
+		if err != nil {
+			return retry, err
+		}
+		err = json.Unmarshal(bodyJSON, &config)
+		if err != nil {
+			return false, errors.Wrap(err, "Error unmarshalling image config")
+		}
+		return false, nil
+	})
+	if err != nil {
+		return config, errors.Wrap(err, "Error retrieving image config blob")
+	}
+	return config, nil
+}
+
+// decodeManifest decodes an image manifest body, making sure it is of a supported media type
+func decodeManifest(body []byte, mediaType string) (imageManifest, error) {
+	var manifest imageManifest
+	if !isManifest(mediaType) {
+		return manifest, fmt.Errorf("Unsupported manifest media type '%s'", mediaType)
+	}
+	err := json.Unmarshal(body, &manifest)
+	if err != nil {
+		return manifest, errors.Wrap(err, "Error unmarshaling image manifest")
+	}
+	return manifest, nil
+}
+
+// fetchPlatformConfig retrieves the image manifest for a single platform, referenced by digest, and its config blob
+func (c *Client) fetchPlatformConfig(name string, digest string, timeout time.Duration) (imageManifest, imageConfig, error) {
+	body, mediaType, _, err := c.fetchManifest(name, digest, timeout)
+	if err != nil {
+		return imageManifest{}, imageConfig{}, err
+	}
+	manifest, err := decodeManifest(body, mediaType)
+	if err != nil {
+		return imageManifest{}, imageConfig{}, err
+	}
+	config, err := c.fetchConfig(name, manifest, timeout)
+	return manifest, config, err
+}
+
+// imageSize returns the download size of an image, which is the size of its config and compressed layers
+func imageSize(manifest imageManifest) int64 {
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// imageInfo collects the details of an image from its manifest, config and the standard OCI annotations. The
+// annotations are read from the image labels and the provided manifest annotations, which take precedence
+func imageInfo(manifest imageManifest, config imageConfig, annotations ...map[string]string) *installer.ImageInfo {
+	values := map[string]string{}
+	for _, set := range append([]map[string]string{config.Config.Labels}, annotations...) {
+		for key, value := range set {
+			if strings.HasPrefix(key, "org.opencontainers.image.") {
+				values[key] = value
+			}
+		}
+	}
+
+	info := &installer.ImageInfo{
+		Size:     imageSize(manifest),
+		Created:  config.Created,
+		Version:  values[ispec.AnnotationVersion],
+		Source:   values[ispec.AnnotationSource],
+		Licenses: values[ispec.AnnotationLicenses],
+		Authors:  values[ispec.AnnotationAuthors],
+		URL:      values[ispec.AnnotationURL],
+	}
+	if info.Created == nil {
+		if created, err := time.Parse(time.RFC3339, values[ispec.AnnotationCreated]); err == nil {
+			info.Created = &created
+		}
+	}
+	return info
+}
@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listIterator walks a paginated registry listing (repositories or tags), following the Link header returned by
+// the registry until all the pages have been retrieved
+type listIterator struct {
+	client  *Client
+	next    string
+	items   []string
+	current string
+	err     error
+	decode  func([]byte) ([]string, error)
+}
+
+// repositories returns an iterator over all the repositories in the registry catalog
+func (c *Client) repositories() *listIterator {
+	return &listIterator{
+		client: c,
+		next:   c.url("/v2/_catalog?n=%d", c.pageSize),
+		decode: func(body []byte) ([]string, error) {
+			var catalog struct {
+				Repositories []string
+			}
+			err := json.Unmarshal(body, &catalog)
+			return catalog.Repositories, err
+		},
+	}
+}
+
+// tags returns an iterator over all the tags of the provided repository
+func (c *Client) tags(name string) *listIterator {
+	return &listIterator{
+		client: c,
+		next:   c.url("/v2/%s/tags/list?n=%d", name, c.pageSize),
+		decode: func(body []byte) ([]string, error) {
+			var tagList struct{ Tags []string }
+			err := json.Unmarshal(body, &tagList)
+			return tagList.Tags, err
+		},
+	}
+}
+
+// Next advances the iterator to the next item, retrieving a new page if required. It returns false when there are
+// no more items or an error occurred, in which case Err should be checked
+func (it *listIterator) Next() bool {
+	for len(it.items) == 0 {
+		if it.next == "" || it.err != nil {
+			return false
+		}
+		it.err = it.fetchPage()
+	}
+	it.current = it.items[0]
+	it.items = it.items[1:]
+	return true
+}
+
+// Value returns the current item
+func (it *listIterator) Value() string {
+	return it.current
+}
+
+// Err returns the first error encountered while retrieving pages
+func (it *listIterator) Err() error {
+	return it.err
+}
+
+func (it *listIterator) fetchPage() error {
+	pageURL := it.next
+	it.next = ""
+	r, err := it.client.get(pageURL, "")
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", pageURL, r.StatusCode)
+	}
+
+	bodyJSON, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	it.items, err = it.decode(bodyJSON)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to decode %s", pageURL)
+	}
+
+	next, err := nextPageURL(pageURL, r.Header.Get("Link"))
+	if err != nil {
+		return err
+	}
+	it.next = next
+	return nil
+}
+
+// nextPageURL extracts the next page from a Link header (`</v2/_catalog?last=b&n=100>; rel="next"`) and resolves it
+// against the URL of the current page. An empty string is returned if there is no next page
+func nextPageURL(current string, link string) (string, error) {
+	for _, entry := range strings.Split(link, ",") {
+		parts := strings.Split(entry, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		isNext := false
+		for _, param := range parts[1:] {
+			if strings.Replace(strings.TrimSpace(param), " ", "", -1) == `rel="next"` {
+				isNext = true
+			}
+		}
+		if !isNext {
+			continue
+		}
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		base, err := url.Parse(current)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(target)
+		if err != nil {
+			return "", errors.Wrapf(err, "Invalid Link header '%s'", link)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// blobExists checks if a blob is already stored in a repository
+func (c *Client) blobExists(name string, d digest.Digest) (bool, error) {
+	r, err := c.head(c.url("/v2/%s/blobs/%s", name, d), "")
+	if err != nil {
+		return false, err
+	}
+	r.Body.Close()
+	switch r.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Checking blob %s returned %d", d, r.StatusCode)
+	}
+}
+
+// uploadBlob uploads a file as a blob, using a monolithic upload. Blobs that already exist are skipped
+func (c *Client) uploadBlob(name string, d digest.Digest, path string) error {
+	exists, err := c.blobExists(name, d)
+	if err != nil {
+		return err
+	} else if exists {
+		log.Debugf("Blob %s already exists in %s", d, name)
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", c.url("/v2/%s/blobs/uploads/", name), nil)
+	if err != nil {
+		return err
+	}
+	r, err := c.do(req)
+	if err != nil {
+		return errors.Wrap(err, "Failed to start blob upload")
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Starting upload for blob %s returned %d", d, r.StatusCode)
+	}
+
+	location, err := url.Parse(r.Header.Get("Location"))
+	if err != nil {
+		return errors.Wrap(err, "Invalid blob upload location")
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return err
+	}
+	location = base.ResolveReference(location)
+	query := location.Query()
+	query.Set("digest", d.String())
+	location.RawQuery = query.Encode()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err = http.NewRequest("PUT", location.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// the body has to be replayable in case the request needs to be authenticated
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+	r, err = c.do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to upload blob %s", d)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("Uploading blob %s returned %d: %s", d, r.StatusCode, string(body))
+	}
+	return nil
+}
+
+// PushArchive uploads all the blobs and the manifest of an image archive to the registry
+func (c *Client) PushArchive(a *Archive) error {
+	log.Infof("Pushing image %s:%s to the registry", a.Name, a.Tag)
+	for d, path := range a.blobs {
+		err := c.uploadBlob(a.Name, d, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("PUT", c.url("/v2/%s/manifests/%s", a.Name, a.Tag), bytes.NewReader(a.manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", a.mediaType)
+	r, err := c.do(req)
+	if err != nil {
+		return errors.Wrap(err, "Failed to upload manifest")
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("Uploading manifest for %s:%s returned %d: %s", a.Name, a.Tag, r.StatusCode, string(body))
+	}
+	if pushed := r.Header.Get("docker-content-digest"); pushed != "" && pushed != a.Digest.String() {
+		return fmt.Errorf("Registry computed digest %s for the manifest, expected %s", pushed, a.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/db"
+	"github.com/protosio/app-store/installer"
+)
+
+// ErrQuarantined is returned when an image is rejected and added to the quarantine
+var ErrQuarantined = errors.New("Image was quarantined")
+
+// QuarantinedImage is an image tag that could not be imported, together with all the problems found
+type QuarantinedImage struct {
+	Repository  string              `json:"repository"`
+	Tag         string              `json:"tag"`
+	Digest      string              `json:"digest,omitempty"`
+	Problems    []installer.Problem `json:"problems"`
+	Attempts    int                 `json:"attempts"`
+	FirstFailed time.Time           `json:"firstfailed"`
+	LastFailed  time.Time           `json:"lastfailed"`
+}
+
+func dbToQuarantinedImage(dbimage db.QuarantinedImage) (QuarantinedImage, error) {
+	image := QuarantinedImage{
+		Repository:  dbimage.Repository,
+		Tag:         dbimage.Tag,
+		Digest:      dbimage.Digest,
+		Attempts:    dbimage.Attempts,
+		FirstFailed: dbimage.FirstFailedAt,
+		LastFailed:  dbimage.LastFailedAt,
+	}
+	err := dbimage.Problems.Unmarshal(&image.Problems)
+	if err != nil {
+		return image, errors.Wrapf(err, "Failed to JSON unmarshal problems for quarantined image %s:%s", dbimage.Repository, dbimage.Tag)
+	}
+	return image, nil
+}
+
+// quarantine records a failed import so publishers can find out why their image was rejected. Failing to record
+// it doesn't change the outcome of the import, so errors are only logged
+func quarantine(name string, tag string, digest string, problems []installer.Problem) {
+	log.Warnf("Quarantining image %s:%s", name, tag)
+	payload, err := json.Marshal(problems)
+	if err == nil {
+		err = db.SaveQuarantinedImage(db.QuarantinedImage{Repository: name, Tag: tag, Digest: digest, Problems: payload})
+	}
+	if err != nil {
+		log.Errorf("Failed to quarantine image %s:%s: %s", name, tag, err.Error())
+	}
+}
+
+// unquarantine removes an image from the quarantine, based on its tag or, if the tag is empty, its digest
+func unquarantine(name string, tag string, digest string) {
+	tags := []string{tag}
+	if tag == "" {
+		dbimages, err := db.GetQuarantinedImages(map[string]interface{}{"repository": name, "digest": digest})
+		if err != nil {
+			log.Errorf("Failed to retrieve quarantined images for %s: %s", name, err.Error())
+			return
+		}
+		tags = []string{}
+		for _, dbimage := range dbimages {
+			tags = append(tags, dbimage.Tag)
+		}
+	}
+	for _, tag := range tags {
+		err := db.DeleteQuarantinedImage(name, tag)
+		if err != nil {
+			log.Errorf("Failed to remove image %s:%s from quarantine: %s", name, tag, err.Error())
+		}
+	}
+}
+
+// GetQuarantinedImages returns the quarantined images, optionally filtered by repository
+func GetQuarantinedImages(repository string) ([]QuarantinedImage, error) {
+	filter := map[string]interface{}{}
+	if repository != "" {
+		filter["repository"] = repository
+	}
+	dbimages, err := db.GetQuarantinedImages(filter)
+	if err != nil {
+		return nil, err
+	}
+	images := []QuarantinedImage{}
+	for _, dbimage := range dbimages {
+		image, err := dbToQuarantinedImage(dbimage)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// Reimport retries the import of quarantined images, optionally filtered by repository and tag. Images that are
+// imported successfully are released from the quarantine, while the others have their problems updated
+func (c *Client) Reimport(repository string, tag string) ([]ImageResult, error) {
+	images, err := GetQuarantinedImages(repository)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve quarantined images")
+	}
+	results := []ImageResult{}
+	for _, image := range images {
+		if tag != "" && image.Tag != tag {
+			continue
+		}
+		log.Infof("Re-importing quarantined image %s:%s", image.Repository, image.Tag)
+		result := ImageResult{Repository: image.Repository, Tag: image.Tag}
+		err := c.importVersion(image.Repository, image.Tag)
+		if err != nil {
+			log.Error(err.Error())
+			result.Error = err.Error()
+			if !permanentError(err) {
+				// the image couldn't be retrieved at all, so the quarantine entry is refreshed with the new error.
+				// Rejected images already have an up to date quarantine entry
+				quarantine(image.Repository, image.Tag, image.Digest, []installer.Problem{
+					{Severity: installer.SeverityError, Message: err.Error()},
+				})
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/db"
+	"github.com/protosio/app-store/installer"
+)
+
+// Processing states of a queued registry event
+const (
+	EventPending   = "pending"
+	EventProcessed = "processed"
+	EventFailed    = "failed"
+)
+
+const (
+	// maxEventAttempts is the number of times an event is processed before it is moved to the failed state
+	maxEventAttempts = 5
+	// eventRetryBackoff is the delay before the first retry of an event. It doubles with every attempt
+	eventRetryBackoff = 30 * time.Second
+	// eventPollInterval is how often the event worker checks for events that are due for a retry
+	eventPollInterval = 10 * time.Second
+	eventBatchSize    = 20
+)
+
+// QueuedEvent is a registry event persisted in the event queue, together with its processing state
+type QueuedEvent struct {
+	Event
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lasterror,omitempty"`
+	Received    time.Time `json:"received"`
+	NextAttempt time.Time `json:"nextattempt"`
+}
+
+func dbToQueuedEvent(dbevent db.Event) (QueuedEvent, error) {
+	event := QueuedEvent{
+		Status:      dbevent.Status,
+		Attempts:    dbevent.Attempts,
+		LastError:   dbevent.LastError,
+		Received:    dbevent.ReceivedAt,
+		NextAttempt: dbevent.NextAttemptAt,
+	}
+	err := dbevent.Payload.Unmarshal(&event.Event)
+	if err != nil {
+		return event, errors.Wrapf(err, "Failed to JSON unmarshal event %s", dbevent.ID)
+	}
+	return event, nil
+}
+
+// QueueEvents persists all the "push" and "delete" events so they can be processed by the event worker. Events
+// that are already in the queue, because the registry delivered them again, are ignored
+func (c *Client) QueueEvents(events []Event) error {
+	queued := false
+	for _, event := range events {
+		if event.Action != "push" && event.Action != "delete" {
+			log.Debug("Ignoring event of type " + event.Action)
+			continue
+		}
+		if event.ID == "" {
+			return errors.New("Received registry event without an id")
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to JSON marshal event %s", event.ID)
+		}
+		inserted, err := db.InsertEvent(db.Event{
+			ID:         event.ID,
+			Action:     event.Action,
+			Repository: event.Target.Repository,
+			Tag:        event.Target.Tag,
+			Payload:    payload,
+			Status:     EventPending,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to persist event %s", event.ID)
+		}
+		if !inserted {
+			log.Debugf("Event %s already received. Ignoring it", event.ID)
+			continue
+		}
+		log.Infof("Queued %s event %s for application %s", event.Action, event.ID, event.Target.Repository)
+		queued = true
+	}
+	if queued {
+		c.wakeEventWorker()
+	}
+	return nil
+}
+
+// wakeEventWorker signals the event worker that new events are available, without waiting for it
+func (c *Client) wakeEventWorker() {
+	select {
+	case c.eventsQueued <- struct{}{}:
+	default:
+	}
+}
+
+// permanentError checks if an event processing error can't be fixed by retrying, because the image has been
+// quarantined or rejected and will only be imported again after the publisher pushes a fix
+func permanentError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == ErrQuarantined || cause == ErrUnsigned
+}
+
+// processQueuedEvent processes a single event and records the outcome. Failed events are retried with an
+// exponential backoff, until maxEventAttempts is reached and they are moved to the failed state. Malformed events
+// and permanent errors are moved to the failed state right away
+func (c *Client) processQueuedEvent(dbevent db.Event) {
+	event, err := dbToQueuedEvent(dbevent)
+	permanent := err != nil
+	if err == nil {
+		switch event.Action {
+		case "push":
+			err = c.processPushEvent(event.Event)
+		case "delete":
+			err = c.processDeleteEvent(event.Event)
+		}
+		permanent = permanentError(err)
+	}
+
+	dbevent.Attempts++
+	if err == nil {
+		dbevent.Status = EventProcessed
+		dbevent.LastError = ""
+	} else if permanent {
+		log.Errorf("Event %s can't be processed: %s", dbevent.ID, err.Error())
+		dbevent.Status = EventFailed
+		dbevent.LastError = err.Error()
+	} else if dbevent.Attempts >= maxEventAttempts {
+		log.Errorf("Giving up on event %s after %d attempts: %s", dbevent.ID, dbevent.Attempts, err.Error())
+		dbevent.Status = EventFailed
+		dbevent.LastError = err.Error()
+		if event.Action == "push" && event.Target.Tag != "" {
+			quarantine(event.Target.Repository, event.Target.Tag, event.Target.Digest, []installer.Problem{
+				{Severity: installer.SeverityError, Message: err.Error()},
+			})
+		}
+	} else {
+		backoff := eventRetryBackoff * time.Duration(1<<uint(dbevent.Attempts-1))
+		log.Warnf("Failed to process event %s (attempt %d), retrying in %s: %s", dbevent.ID, dbevent.Attempts, backoff, err.Error())
+		dbevent.LastError = err.Error()
+		dbevent.NextAttemptAt = time.Now().Add(backoff)
+	}
+
+	err = db.UpdateEvent(dbevent)
+	if err != nil {
+		log.Errorf("Failed to update state for event %s: %s", dbevent.ID, err.Error())
+	}
+}
+
+// processDueEvents processes all the pending events that are due, in the order they were received
+func (c *Client) processDueEvents() {
+	for {
+		dbevents, err := db.GetDueEvents(EventPending, eventBatchSize)
+		if err != nil {
+			log.Errorf("Failed to retrieve queued events: %s", err.Error())
+			return
+		}
+		if len(dbevents) == 0 {
+			return
+		}
+		for _, dbevent := range dbevents {
+			c.processQueuedEvent(dbevent)
+		}
+	}
+}
+
+// StartEventWorker starts the goroutine that processes the queued registry events. Events left over from a
+// previous run are picked up immediately
+func (c *Client) StartEventWorker() {
+	log.Info("Starting registry event worker")
+	go func() {
+		ticker := time.NewTicker(eventPollInterval)
+		defer ticker.Stop()
+		for {
+			c.processDueEvents()
+			select {
+			case <-c.eventsQueued:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// GetQueuedEvents returns the queued events, optionally filtered by status and repository
+func GetQueuedEvents(status string, repository string) ([]QueuedEvent, error) {
+	filter := map[string]interface{}{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if repository != "" {
+		filter["repository"] = repository
+	}
+	dbevents, err := db.GetEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+	events := []QueuedEvent{}
+	for _, dbevent := range dbevents {
+		event, err := dbToQueuedEvent(dbevent)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetQueuedEvent returns a queued event based on its id
+func GetQueuedEvent(id string) (QueuedEvent, bool, error) {
+	dbevents, err := db.GetEvents(map[string]interface{}{"id": id})
+	if err != nil || len(dbevents) == 0 {
+		return QueuedEvent{}, false, err
+	}
+	event, err := dbToQueuedEvent(dbevents[0])
+	return event, err == nil, err
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+
+	"github.com/protosio/app-store/installer"
+)
+
+// ReconcileStatus describes the state of the periodic reconciliation scans
+type ReconcileStatus struct {
+	Schedule     string        `json:"schedule"`
+	Running      bool          `json:"running"`
+	LastRun      time.Time     `json:"lastrun,omitempty"`
+	LastDuration time.Duration `json:"lastduration,omitempty"`
+	LastError    string        `json:"lasterror,omitempty"`
+	LastSummary  *ScanSummary  `json:"lastsummary,omitempty"`
+	Removed      int           `json:"removed"`
+	NextRun      time.Time     `json:"nextrun,omitempty"`
+}
+
+type reconciler struct {
+	mu       sync.Mutex
+	schedule cron.Schedule
+	status   ReconcileStatus
+}
+
+// ParseSchedule parses a scan schedule, which can be either an interval (e.g. "6h") or a standard 5 field cron
+// expression (e.g. "0 3 * * *")
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval < time.Minute {
+			return nil, fmt.Errorf("Scan interval %s is too short. The minimum is 1m", interval)
+		}
+		return cron.Every(interval), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid scan schedule '%s': it is neither a duration nor a cron expression (%s)", spec, err.Error())
+	}
+	return schedule, nil
+}
+
+// prune removes the registry installer versions whose tags were not seen during a scan. It's only safe to call if
+// the scan managed to list every repository and tag in the registry. Versions imported from other sources are kept.
+// Since push events are processed while the scan runs, every tag is checked again before its version is removed
+func (c *Client) prune(summary ScanSummary) (int, error) {
+	seen := map[string]bool{}
+	for _, result := range summary.Results {
+		seen[result.Repository+":"+result.Tag] = true
+	}
+
+	installers, err := installer.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, inst := range installers {
+		for version, metadata := range inst.VersionMetadata {
+			// versions imported before sources were recorded all come from the registry
+			if metadata.Source != "" && metadata.Source != SourceName {
+				continue
+			}
+			if seen[inst.Name+":"+version] {
+				continue
+			}
+			_, err := c.getManifestDigest(inst.Name, version)
+			if err == nil {
+				log.Debugf("Version %s of installer %s was pushed during the scan. Keeping it", version, inst.Name)
+				continue
+			} else if errors.Cause(err) != ErrNotFound {
+				log.Warnf("Could not check if version %s of installer %s is still in the registry. Keeping it: %s", version, inst.Name, err.Error())
+				continue
+			}
+			log.Infof("Version %s of installer %s is not in the registry anymore", version, inst.Name)
+			err = installer.RemoveVersion(inst.Name, version, "")
+			if err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// reconcile runs a single reconciliation scan: it imports the new and changed tags and removes the versions that
+// were deleted from the registry without the app store being notified
+func (c *Client) reconcile() {
+	r := c.reconciler
+	r.mu.Lock()
+	r.status.Running = true
+	r.mu.Unlock()
+
+	log.Info("Starting registry reconciliation scan")
+	summary, err := c.FullScan(false)
+	removed := 0
+	if err == nil && summary.listingFailed() {
+		log.Warn("Not all repositories could be listed. Skipping removal of deleted versions")
+	} else if err == nil {
+		removed, err = c.prune(summary)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Running = false
+	r.status.LastRun = summary.Started
+	r.status.LastDuration = summary.Duration
+	r.status.LastSummary = &summary
+	r.status.Removed = removed
+	r.status.LastError = ""
+	if err != nil {
+		r.status.LastError = err.Error()
+		log.Errorf("Registry reconciliation scan failed: %s", err.Error())
+	} else {
+		log.Infof("Registry reconciliation scan finished in %s: %d imported, %d skipped, %d failed, %d removed", summary.Duration, summary.Imported, summary.Skipped, summary.Failed, removed)
+	}
+}
+
+// StartReconciler periodically runs reconciliation scans according to the provided schedule, so the catalog
+// converges with the registry even if notifications are lost
+func (c *Client) StartReconciler(spec string) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	c.reconciler = &reconciler{schedule: schedule, status: ReconcileStatus{Schedule: spec}}
+	log.Infof("Registry reconciliation scans scheduled using '%s'", spec)
+
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			c.reconciler.mu.Lock()
+			c.reconciler.status.NextRun = next
+			c.reconciler.mu.Unlock()
+			time.Sleep(time.Until(next))
+			c.reconcile()
+		}
+	}()
+	return nil
+}
+
+// ReconcileStatus returns the state of the periodic reconciliation scans, and false if they are not enabled
+func (c *Client) ReconcileStatus() (ReconcileStatus, bool) {
+	if c.reconciler == nil {
+		return ReconcileStatus{}, false
+	}
+	c.reconciler.mu.Lock()
+	defer c.reconciler.mu.Unlock()
+	return c.reconciler.status, true
+}
@@ -3,23 +3,31 @@ package registry
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 
-	"github.com/docker/distribution/manifest/schema2"
-	"github.com/docker/docker/api/types"
 	"github.com/protosio/app-store/installer"
 	"github.com/protosio/app-store/util"
 )
 
 var log = util.GetLogger()
 
+// SourceName identifies the installer versions imported from the Docker registry
+const SourceName = "registry"
+
+var _ installer.Source = (*Client)(nil)
+
+const (
+	// readinessTimeout is how long we wait for a manifest or blob to become available after a push
+	readinessTimeout   = 30 * time.Second
+	initialPollBackoff = 250 * time.Millisecond
+	maxPollBackoff     = 5 * time.Second
+)
+
 type Target struct {
 	MediaType  string `json:"mediaType"`
 	Size       int    `json:"size"`
@@ -41,225 +49,323 @@ type Events struct {
 	Events []Event `json:"events"`
 }
 
-func parsePublicPorts(publicports string) []util.Port {
-	ports := []util.Port{}
-	for _, portstr := range strings.Split(publicports, ",") {
-		portParts := strings.Split(portstr, "/")
-		if len(portParts) != 2 {
-			log.Errorf("Error parsing installer port string %s", portstr)
-			continue
+// Name returns the name of the registry installer source
+func (c *Client) Name() string {
+	return SourceName
+}
+
+// Installers returns the names of all the repositories in the registry
+func (c *Client) Installers() ([]string, error) {
+	names := []string{}
+	it := c.repositories()
+	for it.Next() {
+		names = append(names, it.Value())
+	}
+	return names, it.Err()
+}
+
+// Versions returns all the tags of a repository
+func (c *Client) Versions(name string) ([]string, error) {
+	return c.getImageTags(name)
+}
+
+// Metadata returns the installer metadata of a repository tag
+func (c *Client) Metadata(name string, version string) (installer.InstallerMetadata, error) {
+	return c.getImageMetadata(name, version)
+}
+
+func (c *Client) getImageTags(name string) ([]string, error) {
+	tags := []string{}
+	log.Infof("Retrieving tags for Docker image %s", name)
+	it := c.tags(name)
+	for it.Next() {
+		// cosign signatures are stored as tags next to the images they sign
+		if !isSignatureTag(it.Value()) {
+			tags = append(tags, it.Value())
 		}
-		portNr, err := strconv.Atoi(portParts[0])
-		if err != nil {
-			log.Errorf("Error parsing installer port string %s", portstr)
-			continue
+	}
+	return tags, it.Err()
+}
+
+// pollWithBackoff calls fn until it succeeds, returns an error that should not be retried, or the timeout expires.
+// Between attempts it waits for an exponentially increasing amount of time, capped at maxPollBackoff. A zero timeout
+// calls fn only once
+func pollWithBackoff(timeout time.Duration, fn func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	backoff := initialPollBackoff
+	for {
+		retry, err := fn()
+		if err == nil || !retry || timeout == 0 {
+			return err
 		}
-		if portNr < 1 || portNr > 0xffff {
-			log.Errorf("Installer port is out of range %s (valid range is 1-65535)", portstr)
-			continue
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.Wrapf(err, "Gave up after %s", timeout)
 		}
-		port := util.Port{Nr: portNr}
-		if strings.ToUpper(portParts[1]) == string(util.TCP) {
-			port.Type = util.TCP
-		} else if strings.ToUpper(portParts[1]) == string(util.UDP) {
-			port.Type = util.UDP
-		} else {
-			log.Errorf("Invalid protocol(%s) for port(%s)", portParts[1], portParts[0])
-			continue
+		log.Debugf("Resource not ready (%s). Retrying in %s", err.Error(), backoff)
+		time.Sleep(backoff)
+		backoff = backoff * 2
+		if backoff > maxPollBackoff {
+			backoff = maxPollBackoff
 		}
-		ports = append(ports, port)
 	}
-	return ports
 }
 
-// parseMetadata parses the image metadata from the image labels
-func parseMetadata(labels map[string]string) (installer.InstallerMetadata, error) {
-	r := regexp.MustCompile("(^protos.installer.metadata.)(\\w+)")
-	metadata := installer.InstallerMetadata{}
-	for label, value := range labels {
-		labelParts := r.FindStringSubmatch(label)
-		if len(labelParts) == 3 {
-			switch labelParts[2] {
-			case "capabilities":
-				capabilities := strings.Split(value, ",")
-				caps := []map[string]string{}
-				for _, cap := range capabilities {
-					caps = append(caps, map[string]string{"Name": cap})
-				}
-				metadata.Capabilities = caps
-			case "params":
-				metadata.Params = strings.Split(value, ",")
-			case "provides":
-				metadata.Provides = strings.Split(value, ",")
-			case "requires":
-				metadata.Requires = strings.Split(value, ",")
-			case "publicports":
-				metadata.PublicPorts = parsePublicPorts(value)
-			case "description":
-				metadata.Description = value
-			}
-		}
+// ErrNotFound is returned when a repository, tag or blob does not exist in the registry
+var ErrNotFound = errors.New("Not found in the registry")
 
+// getManifestDigest returns the digest of the manifest a tag currently points to, without downloading the manifest
+func (c *Client) getManifestDigest(name string, tag string) (string, error) {
+	url := c.url("/v2/%s/manifests/%s", name, tag)
+	r, err := c.head(url, manifestAccept)
+	if err != nil {
+		return "", err
+	}
+	r.Body.Close()
+	if r.StatusCode == http.StatusNotFound {
+		return "", errors.Wrapf(ErrNotFound, "%s returned %d", url, r.StatusCode)
+	} else if r.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %d", url, r.StatusCode)
 	}
-	if metadata.Description == "" {
-		return metadata, errors.New("installer metadata field 'description' is mandatory")
+	digest := r.Header.Get("docker-content-digest")
+	if digest == "" {
+		return "", errors.New("The image digest is empty")
 	}
-	return metadata, nil
+	return digest, nil
 }
 
-func getImageTags(name string) ([]string, error) {
-	var tagList struct{ Tags []string }
-	log.Infof("Retrieving tags for Docker image %s", name)
-	r, err := http.Get(fmt.Sprintf("http://docker-registry:5000/v2/%s/tags/list", name))
-	if err != nil {
-		return tagList.Tags, err
-	}
-	defer r.Body.Close()
+// image holds the installer metadata of an image tag, together with the manifest and labels of its preferred platform
+type image struct {
+	metadata  installer.InstallerMetadata
+	manifest  imageManifest
+	labels    map[string]string
+	info      *installer.ImageInfo
+	problems  []installer.Problem
+	digest    string
+	platforms map[string]installer.Platform
+}
+
+// fetchImage retrieves the manifest and config of an image tag. If the tag points to a manifest list or an OCI
+// index, every platform in it is recorded and the manifest and labels are taken from the preferred platform. Missing
+// manifests and blobs are polled for until the timeout expires
+func (c *Client) fetchImage(name string, tag string, timeout time.Duration) (image, error) {
+	var img image
+	log.Infof("Retrieving metadata for image %s:%s", name, tag)
 
-	bodyJSON, err := ioutil.ReadAll(r.Body)
+	// Retrieves the manifest for the image, based on the tag. From that we extract the image/tag digest.
+	// Push events can arrive before the registry finished processing the image, so the manifest is polled until available
+	body, mediaType, imageDigest, err := c.fetchManifest(name, tag, timeout)
 	if err != nil {
-		return tagList.Tags, err
+		return img, errors.Wrap(err, "Failed to retrieve manifest")
 	}
 
-	err = json.Unmarshal(bodyJSON, &tagList)
-	if err != nil {
-		return tagList.Tags, err
+	platforms := map[string]installer.Platform{}
+	if isIndex(mediaType) {
+		var index imageIndex
+		err = json.Unmarshal(body, &index)
+		if err != nil {
+			return img, errors.Wrap(err, "Error unmarshaling image index")
+		}
+		preferred, err := selectPlatform(index)
+		if err != nil {
+			return img, err
+		}
+		for _, desc := range index.Manifests {
+			if !isPlatformManifest(desc) {
+				continue
+			}
+			manifest, config, err := c.fetchPlatformConfig(name, desc.Digest.String(), timeout)
+			if err != nil {
+				return img, errors.Wrapf(err, "Failed to retrieve image for platform %s/%s", desc.Platform.OS, desc.Platform.Architecture)
+			}
+			platform := installer.Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+				Digest:       desc.Digest.String(),
+				Size:         imageSize(manifest),
+				Labels:       installer.MetadataLabels(config.Config.Labels),
+			}
+			platforms[platform.Key()] = platform
+			if desc.Digest == preferred.Digest {
+				img.manifest = manifest
+				img.labels = config.Config.Labels
+				img.info = imageInfo(manifest, config, index.Annotations, manifest.Annotations)
+			}
+		}
+	} else {
+		manifest, err := decodeManifest(body, mediaType)
+		if err != nil {
+			return img, err
+		}
+		config, err := c.fetchConfig(name, manifest, timeout)
+		if err != nil {
+			return img, err
+		}
+		platform := installer.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+			Digest:       imageDigest,
+			Size:         imageSize(manifest),
+			Labels:       installer.MetadataLabels(config.Config.Labels),
+		}
+		platforms[platform.Key()] = platform
+		img.manifest = manifest
+		img.labels = config.Config.Labels
+		img.info = imageInfo(manifest, config, manifest.Annotations)
 	}
 
-	return tagList.Tags, nil
+	img.digest = imageDigest
+	img.platforms = platforms
+	return img, nil
 }
 
-func getImageMetadata(name string, tag string) (installer.InstallerMetadata, error) {
-	var metadata installer.InstallerMetadata
-	// this nasty sleep is required (for now) because the push even is triggered before the metadata is available for an image
-	time.Sleep(5 * time.Second)
-	log.Infof("Retrieving metadata for image %s:%s", name, tag)
-	httpClient := &http.Client{}
-
-	// Retrieves the v2 manifest for the Docker image, based on the tag. From that we extract the image/tag digest.
-	url := fmt.Sprintf("http://docker-registry:5000/v2/%s/manifests/%s", name, tag)
-	req, err := http.NewRequest("GET", url, nil)
+// inspectImage retrieves an image tag and parses the installer metadata from its labels
+func (c *Client) inspectImage(name string, tag string) (image, error) {
+	img, err := c.fetchImage(name, tag, readinessTimeout)
 	if err != nil {
-		return metadata, errors.Wrap(err, "Failed to retrieve manifest")
+		return img, err
 	}
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	r, err := httpClient.Do(req)
+	img.metadata, img.problems = installer.LintMetadata(img.labels)
+	err = installer.ProblemsError(img.problems)
 	if err != nil {
-		return metadata, errors.Wrap(err, "Failed to retrieve manifest")
+		return img, errors.Wrap(err, "Could not parse metadata for image")
 	}
-	defer r.Body.Close()
-
-	imageDigest := r.Header.Get("docker-content-digest")
-	if imageDigest == "" {
-		return metadata, errors.New("The image digest is empty")
+	for _, problem := range img.problems {
+		log.Warnf("Installer metadata problem in %s:%s: %s", name, tag, problem.String())
 	}
+	img.metadata.PlatformID = name + "@" + img.digest
+	img.metadata.Platforms = img.platforms
+	img.metadata.Image = img.info
+	img.metadata.Source = SourceName
 
-	bodyJSON, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return metadata, errors.Wrap(err, "Failed to read manifest body")
+	return img, nil
+}
+
+// ImageLabels returns the labels of an image tag, without validating the installer metadata they contain. For
+// multi-platform images, the labels of the preferred platform are returned. The image is fetched once, without
+// waiting for it to become available, so a missing image fails right away with ErrNotFound
+func (c *Client) ImageLabels(name string, tag string) (map[string]string, error) {
+	img, err := c.fetchImage(name, tag, 0)
+	return img.labels, err
+}
+
+func (c *Client) getImageMetadata(name string, tag string) (installer.InstallerMetadata, error) {
+	img, err := c.inspectImage(name, tag)
+	return img.metadata, err
+}
+
+// importVersion imports an image tag as an installer version, together with the installer thumbnail if the tag is
+// the latest version of the installer. Images with invalid installer metadata are quarantined, and released from
+// quarantine once they are imported successfully
+func (c *Client) importVersion(name string, tag string) error {
+	img, err := c.inspectImage(name, tag)
+	if installer.ProblemsError(img.problems) != nil {
+		quarantine(name, tag, img.digest, img.problems)
+		return errors.Wrapf(ErrQuarantined, "Invalid installer metadata for '%s'(%s): %s", name, tag, err.Error())
+	} else if err != nil {
+		return errors.Wrapf(err, "Could not process image metadata for '%s'(%s)", name, tag)
 	}
 
-	var manifest schema2.Manifest
-	err = json.Unmarshal(bodyJSON, &manifest)
+	err = c.checkSignature(name, tag, &img)
 	if err != nil {
-		return metadata, errors.Wrap(err, "Error unmarshaling image manifest")
+		return err
 	}
 
-	// Retrieves the image inspect data which contains the installer metadata
-	url = fmt.Sprintf("http://docker-registry:5000/v2/%s/blobs/%s", name, manifest.Config.Digest.String())
-	r, err = http.Get(url)
+	err = installer.Add(name, tag, img.metadata)
 	if err != nil {
-		return metadata, errors.Wrap(err, "Error retrieving image blob")
+		return errors.Wrapf(err, "Could not save installer %s(%s)", name, tag)
 	}
-	defer r.Body.Close()
+	unquarantine(name, tag, "")
 
-	bodyJSON, err = ioutil.ReadAll(r.Body)
+	// a missing or broken thumbnail doesn't prevent the installer from being published
+	latest, err := installer.IsThumbnailVersion(name, tag)
 	if err != nil {
-		return metadata, errors.Wrap(err, "Error reading image inspect data")
+		log.Warnf("Could not check the thumbnail version of %s(%s): %s", name, tag, err.Error())
+		return nil
+	} else if !latest {
+		log.Debugf("Not updating the thumbnail of %s from %s, which is not its latest version", name, tag)
+		return nil
 	}
-	var imageInfo types.ImageInspect
-	err = json.Unmarshal(bodyJSON, &imageInfo)
+	data, found, err := findThumbnail(img.labels, img.manifest.Layers, func(d digest.Digest) (io.ReadCloser, error) {
+		return c.openBlob(name, d)
+	})
 	if err != nil {
-		return metadata, errors.Wrap(err, "Error unmarshalling image inspect data")
+		log.Warnf("Could not retrieve thumbnail for %s(%s): %s", name, tag, err.Error())
+	} else if found {
+		err = installer.SetThumbnail(name, data)
+		if err != nil {
+			log.Warnf("Could not save thumbnail for %s(%s): %s", name, tag, err.Error())
+		}
 	}
-	metadata, err = parseMetadata(imageInfo.Config.Labels)
-	if err != nil {
-		return metadata, errors.Wrap(err, "Could not parse metadata for image")
+	return nil
+}
+
+func (c *Client) processPushEvent(event Event) error {
+	if event.Target.Tag == "" {
+		log.Errorf("Push event for application %s does not contain a tag. Ignoring", event.Target.Repository)
+		return nil
+	}
+	if isSignatureTag(event.Target.Tag) {
+		return c.processSignatureEvent(event)
 	}
-	metadata.PlatformID = name + "@" + imageDigest
+	log.Infof("Processing push event for application %s with tag %s", event.Target.Repository, event.Target.Tag)
 
-	return metadata, nil
+	return c.importVersion(event.Target.Repository, event.Target.Tag)
 }
 
-// FullScan does a full scan of all the images in the registry and imports them
-func FullScan() error {
-	log.Info("Performing full Docker registry scan")
-	r, err := http.Get("http://docker-registry:5000/v2/_catalog")
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
+// processSignatureEvent re-imports the tags that point to a newly signed image digest, so that quarantined or
+// flagged versions pick up the signature
+func (c *Client) processSignatureEvent(event Event) error {
+	name := event.Target.Repository
+	imageDigest := signedDigest(event.Target.Tag)
+	log.Infof("Processing signature push event for application %s and digest %s", name, imageDigest)
 
-	bodyJSON, err := ioutil.ReadAll(r.Body)
+	tags := map[string]bool{}
+	quarantined, err := GetQuarantinedImages(name)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "Could not retrieve quarantined images for %s", name)
 	}
-
-	var catalog struct {
-		Repositories []string
+	for _, image := range quarantined {
+		if image.Digest == imageDigest {
+			tags[image.Tag] = true
+		}
 	}
-	err = json.Unmarshal(bodyJSON, &catalog)
+	inst, found, err := installer.GetByName(name)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "Could not retrieve installer %s", name)
+	} else if found {
+		for version, metadata := range inst.VersionMetadata {
+			if metadata.PlatformID == name+"@"+imageDigest {
+				tags[version] = true
+			}
+		}
 	}
 
-	for _, image := range catalog.Repositories {
-		tags, err := getImageTags(image)
+	for tag := range tags {
+		err = c.importVersion(name, tag)
 		if err != nil {
-			log.Errorf("Failed to retrieve tags for %s: %s", image, err.Error())
-		}
-		for _, tag := range tags {
-			metadata, err := getImageMetadata(image, tag)
-			if err != nil {
-				log.Error(err.Error())
-			}
-			err = installer.Add(image, tag, metadata)
-			if err != nil {
-				log.Errorf("Could not save installer %s(%s): %s", image, tag, err.Error())
-			}
+			log.Error(err.Error())
 		}
 	}
-
 	return nil
 }
 
-func processPushEvent(event Event) {
-	if event.Target.Tag == "" {
-		log.Errorf("Push event for application %s does not containg a tag. Ignoring", event.Target.Repository)
+func (c *Client) processDeleteEvent(event Event) error {
+	if event.Target.Tag == "" && event.Target.Digest == "" {
+		log.Errorf("Delete event for application %s does not contain a tag or a digest. Ignoring", event.Target.Repository)
+		return nil
 	}
-	log.Infof("Processing push event for application %s with tag %s", event.Target.Repository, event.Target.Tag)
-
-	metadata, err := getImageMetadata(event.Target.Repository, event.Target.Tag)
-	if err != nil {
-		log.Errorf("Could not process image metadata for '%s'(%s): %s", event.Target.Repository, event.Target.Tag, err.Error())
-		return
+	if isSignatureTag(event.Target.Tag) {
+		log.Debugf("Ignoring delete event for signature %s of application %s", event.Target.Tag, event.Target.Repository)
+		return nil
 	}
+	log.Infof("Processing delete event for application %s with tag '%s' and digest '%s'", event.Target.Repository, event.Target.Tag, event.Target.Digest)
 
-	err = installer.Add(event.Target.Repository, event.Target.Tag, metadata)
+	err := installer.RemoveVersion(event.Target.Repository, event.Target.Tag, event.Target.Digest)
 	if err != nil {
-		log.Errorf("Could not save installer %s(%s): %s", event.Target.Repository, event.Target.Tag, err.Error())
-		return
-	}
-}
-
-// ProcessEvents takes an events array and process all the events of type "push"
-func ProcessEvents(events []Event) {
-	for _, event := range events {
-		if event.Action != "push" {
-			log.Debug("Ignoring event of type " + event.Action)
-			continue
-		}
-		log.Info("Received push event from registry")
-		go processPushEvent(event)
+		return errors.Wrapf(err, "Could not remove installer %s(%s)", event.Target.Repository, event.Target.Tag)
 	}
+	unquarantine(event.Target.Repository, event.Target.Tag, event.Target.Digest)
+	return nil
 }
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/protosio/app-store/db"
+	"github.com/protosio/app-store/installer"
+)
+
+// scanLockKey is the Postgres advisory lock key that prevents registry scans from overlapping
+const scanLockKey = 0x70726f746f73
+
+// ErrScanRunning is returned when a scan is requested while another one is in progress
+var ErrScanRunning = errors.New("Another registry scan is already running")
+
+// ImageResult holds the outcome of importing a single image tag during a scan. Tag is empty if the tags for the
+// repository could not be retrieved
+type ImageResult struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ScanSummary aggregates the results of a registry scan
+type ScanSummary struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Results  []ImageResult `json:"results"`
+}
+
+func (s *ScanSummary) add(result ImageResult) {
+	if result.Error != "" {
+		s.Failed++
+	} else if result.Skipped {
+		s.Skipped++
+	} else {
+		s.Imported++
+	}
+	s.Results = append(s.Results, result)
+}
+
+// listingFailed checks if the tags of any repository could not be retrieved during the scan
+func (s *ScanSummary) listingFailed() bool {
+	for _, result := range s.Results {
+		if result.Tag == "" && result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type scanJob struct {
+	repository string
+	tag        string
+}
+
+// unchanged checks if the digest a tag points to is the same as the one already stored for that version
+func (c *Client) unchanged(job scanJob) (bool, error) {
+	inst, found, err := installer.GetByName(job.repository)
+	if err != nil || !found {
+		return false, err
+	}
+	metadata, found := inst.VersionMetadata[job.tag]
+	// versions imported before platforms and image details were recorded are refreshed, and versions are verified
+	// again when the signature policy or the publisher keys change
+	if !found || len(metadata.Platforms) == 0 || metadata.Image == nil || verifiedWith(metadata) != c.verifier.fingerprint {
+		return false, nil
+	}
+	digest, err := c.getManifestDigest(job.repository, job.tag)
+	if err != nil {
+		return false, err
+	}
+	return metadata.PlatformID == job.repository+"@"+digest, nil
+}
+
+// importImage retrieves the metadata for a single image tag and adds it to the installer catalog. Unless force is
+// set, tags whose manifest digest did not change since the last import are skipped
+func (c *Client) importImage(job scanJob, force bool) ImageResult {
+	result := ImageResult{Repository: job.repository, Tag: job.tag}
+	if !force {
+		unchanged, err := c.unchanged(job)
+		if err != nil {
+			log.Warnf("Could not determine if %s:%s changed, importing it: %s", job.repository, job.tag, err.Error())
+		} else if unchanged {
+			log.Debugf("Digest for %s:%s unchanged. Skipping it", job.repository, job.tag)
+			result.Skipped = true
+			return result
+		}
+	}
+	err := c.importVersion(job.repository, job.tag)
+	if err != nil {
+		log.Error(err.Error())
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// FullScan does a full scan of all the images in the registry and imports them. Images are processed by a pool
+// of workers, bounded by the configured scan concurrency. Tags that did not change since they were last imported
+// are skipped, unless force is set. Only one scan can run at a time, across all app store processes
+func (c *Client) FullScan(force bool) (ScanSummary, error) {
+	summary := ScanSummary{Started: time.Now(), Results: []ImageResult{}}
+	unlock, locked, err := db.TryAdvisoryLock(scanLockKey)
+	if err != nil {
+		return summary, err
+	} else if !locked {
+		return summary, ErrScanRunning
+	}
+	defer unlock()
+
+	log.Infof("Performing full Docker registry scan using %d workers", c.scanWorkers)
+
+	jobs := make(chan scanJob)
+	results := make(chan ImageResult)
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.scanWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				results <- c.importImage(job, force)
+			}
+		}()
+	}
+
+	// the producer walks the registry catalog and feeds the workers. Tag listing failures are reported directly
+	var listErr error
+	go func() {
+		repositories := c.repositories()
+		for repositories.Next() {
+			image := repositories.Value()
+			tags, err := c.getImageTags(image)
+			if err != nil {
+				log.Errorf("Failed to retrieve tags for %s: %s", image, err.Error())
+				results <- ImageResult{Repository: image, Error: err.Error()}
+			}
+			for _, tag := range tags {
+				jobs <- scanJob{repository: image, tag: tag}
+			}
+		}
+		listErr = repositories.Err()
+		close(jobs)
+		workers.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		summary.add(result)
+	}
+	summary.Duration = time.Since(summary.Started)
+	log.Infof("Registry scan finished in %s: %d images imported, %d skipped, %d failed", summary.Duration, summary.Imported, summary.Skipped, summary.Failed)
+
+	return summary, listErr
+}
@@ -0,0 +1,344 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/installer"
+)
+
+// Signature policies, which decide what happens with images that don't have a valid signature
+const (
+	PolicyDisabled = "disabled"
+	PolicyFlag     = "flag"
+	PolicyReject   = "reject"
+)
+
+const (
+	// signatureTagSuffix is the suffix of the tags that hold cosign signatures, named sha256-<hex>.sig
+	signatureTagSuffix = ".sig"
+	// signatureAnnotation is the layer annotation holding the base64 encoded signature of the layer payload
+	signatureAnnotation = "dev.cosignproject.cosign/signature"
+	// publisherKeyExt is the file extension of the publisher public keys
+	publisherKeyExt = ".pub"
+	// maxSignaturePayload limits the size of a signature payload blob
+	maxSignaturePayload = 1 << 20
+)
+
+// ErrUnsigned is returned when an image is rejected because it doesn't have a valid signature
+var ErrUnsigned = errors.New("Image does not have a valid signature")
+
+// signaturePayload is the simple signing payload that cosign signs
+type signaturePayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// publisherKey is the public key of a trusted publisher
+type publisherKey struct {
+	publisher string
+	key       crypto.PublicKey
+}
+
+// verifier checks image signatures against the configured publisher keys
+type verifier struct {
+	policy string
+	keys   []publisherKey
+	// fingerprint identifies the policy and the publisher keys, and is recorded with every signature check so
+	// versions are verified again when either of them changes. It is empty when signatures are disabled
+	fingerprint string
+}
+
+// validatePolicy checks if policy is one of the known signature policies
+func validatePolicy(policy string) error {
+	switch policy {
+	case PolicyDisabled, PolicyFlag, PolicyReject:
+		return nil
+	}
+	return fmt.Errorf("Invalid signature policy '%s'. Valid policies are %s, %s and %s", policy, PolicyDisabled, PolicyFlag, PolicyReject)
+}
+
+// newVerifier validates the signature policy and loads the publisher keys from keyDir
+func newVerifier(policy string, keyDir string) (*verifier, error) {
+	v := &verifier{policy: policy, keys: []publisherKey{}}
+	err := validatePolicy(policy)
+	if err != nil {
+		return nil, err
+	} else if policy == PolicyDisabled {
+		return v, nil
+	}
+	if keyDir == "" {
+		return nil, fmt.Errorf("Signature policy '%s' requires a publisher key directory", policy)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(keyDir, "*"+publisherKeyExt))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list publisher keys")
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		key, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		publisher := strings.TrimSuffix(filepath.Base(path), publisherKeyExt)
+		v.keys = append(v.keys, publisherKey{publisher: publisher, key: key})
+		log.Debugf("Loaded public key for publisher %s", publisher)
+	}
+	if len(v.keys) == 0 {
+		log.Warnf("No publisher keys found in %s. No image will pass signature verification", keyDir)
+	}
+	v.fingerprint, err = fingerprint(policy, v.keys)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// fingerprint hashes the signature policy together with the publisher names and keys
+func fingerprint(policy string, keys []publisherKey) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(policy + "\n"))
+	for _, pk := range keys {
+		der, err := x509.MarshalPKIXPublicKey(pk.key)
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to encode the key of publisher %s", pk.publisher)
+		}
+		hash.Write([]byte(pk.publisher + "\n"))
+		hash.Write(der)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// verifiedWith returns the fingerprint of the verifier an installer version was checked with, if any
+func verifiedWith(metadata installer.InstallerMetadata) string {
+	if metadata.Signature == nil {
+		return ""
+	}
+	return metadata.Signature.Verifier
+}
+
+// UnverifiableSignature applies the signature policy to installer versions imported from a source that can't hold
+// signatures, like image archives and local directories. Under the reject policy nothing can be imported from
+// such a source, and under the flag policy the returned signature marks the versions as unsigned
+func UnverifiableSignature(policy string, source string) (*installer.Signature, error) {
+	err := validatePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	switch policy {
+	case PolicyFlag:
+		return &installer.Signature{Status: installer.SignatureUnsigned, Message: "Signatures can't be verified for installers imported from " + source}, nil
+	case PolicyReject:
+		return nil, errors.Wrapf(ErrUnsigned, "Signatures can't be verified for installers imported from %s", source)
+	}
+	return nil, nil
+}
+
+// readPublicKey reads a PEM encoded ECDSA, RSA or Ed25519 public key
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	keyPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read publisher key")
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in publisher key %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse publisher key %s", path)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("Unsupported key type %T in publisher key %s", key, path)
+	}
+}
+
+// verifySignature checks the signature of a payload against a public key
+func verifySignature(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return false
+		}
+		return ecdsa.Verify(k, hash[:], sig.R, sig.S)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// isSignatureTag checks if a tag holds a cosign signature instead of an installer version
+func isSignatureTag(tag string) bool {
+	return strings.HasPrefix(tag, "sha256-") && strings.HasSuffix(tag, signatureTagSuffix)
+}
+
+// signatureTag returns the tag that holds the signature of an image digest
+func signatureTag(imageDigest string) string {
+	return strings.Replace(imageDigest, ":", "-", 1) + signatureTagSuffix
+}
+
+// signedDigest returns the image digest a signature tag refers to
+func signedDigest(tag string) string {
+	return strings.Replace(strings.TrimSuffix(tag, signatureTagSuffix), "-", ":", 1)
+}
+
+// verifyImage looks for a signature of the image digest, stored in the same repository, and checks it against
+// the publisher keys. The returned error is only set if the signature could not be retrieved
+func (c *Client) verifyImage(name string, imageDigest string) (installer.Signature, error) {
+	signature := installer.Signature{Status: installer.SignatureUnsigned}
+	url := c.url("/v2/%s/manifests/%s", name, signatureTag(imageDigest))
+	r, err := c.get(url, manifestAccept)
+	if err != nil {
+		return signature, errors.Wrap(err, "Failed to retrieve image signature")
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusNotFound {
+		signature.Message = "No signature found for " + imageDigest
+		return signature, nil
+	} else if r.StatusCode != http.StatusOK {
+		return signature, fmt.Errorf("%s returned %d", url, r.StatusCode)
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return signature, errors.Wrap(err, "Failed to retrieve image signature")
+	}
+	manifest, err := decodeManifest(body, manifestMediaType(r.Header.Get("Content-Type"), body))
+	if err != nil {
+		return signature, err
+	}
+	signature.Digest = r.Header.Get("docker-content-digest")
+
+	signature.Status = installer.SignatureInvalid
+	signature.Message = "No signature matches a trusted publisher key"
+	for _, layer := range manifest.Layers {
+		encoded, found := layer.Annotations[signatureAnnotation]
+		if !found {
+			continue
+		}
+		payload, err := c.fetchSignaturePayload(name, layer.Digest)
+		if err != nil {
+			return signature, err
+		}
+		err = checkPayload(payload, name, imageDigest)
+		if err != nil {
+			signature.Message = err.Error()
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			signature.Message = "Signature is not base64 encoded"
+			continue
+		}
+		for _, pk := range c.verifier.keys {
+			if verifySignature(pk.key, payload, sig) {
+				signature.Status = installer.SignatureVerified
+				signature.Publisher = pk.publisher
+				signature.Message = ""
+				return signature, nil
+			}
+		}
+	}
+	return signature, nil
+}
+
+// fetchSignaturePayload downloads a signature payload blob and checks its digest
+func (c *Client) fetchSignaturePayload(name string, d digest.Digest) ([]byte, error) {
+	blob, err := c.openBlob(name, d)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve signature payload")
+	}
+	defer blob.Close()
+	payload, err := ioutil.ReadAll(io.LimitReader(blob, maxSignaturePayload+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve signature payload")
+	} else if len(payload) > maxSignaturePayload {
+		return nil, errors.New("Signature payload is too large")
+	}
+	if err := d.Validate(); err != nil || d.Algorithm().FromBytes(payload) != d {
+		return nil, fmt.Errorf("Signature payload does not match digest %s", d)
+	}
+	return payload, nil
+}
+
+// checkPayload makes sure a signature payload refers to the image being verified, so a signature can't be copied
+// from another image
+func checkPayload(payload []byte, name string, imageDigest string) error {
+	var p signaturePayload
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return errors.Wrap(err, "Error unmarshalling signature payload")
+	}
+	if p.Critical.Image.DockerManifestDigest != imageDigest {
+		return fmt.Errorf("Signature is for digest %s instead of %s", p.Critical.Image.DockerManifestDigest, imageDigest)
+	}
+	reference := p.Critical.Identity.DockerReference
+	if reference != name && !strings.HasSuffix(reference, "/"+name) {
+		return fmt.Errorf("Signature is for image %s instead of %s", reference, name)
+	}
+	return nil
+}
+
+// checkSignature verifies an image according to the signature policy and records the outcome in the metadata.
+// Under the reject policy, images without a valid signature are quarantined and unpublished, in case they were
+// imported before the policy or the publisher keys changed
+func (c *Client) checkSignature(name string, tag string, img *image) error {
+	if c.verifier.policy == PolicyDisabled {
+		return nil
+	}
+	signature, err := c.verifyImage(name, img.digest)
+	if err != nil {
+		return errors.Wrapf(err, "Could not verify signature of '%s'(%s)", name, tag)
+	}
+	signature.Verifier = c.verifier.fingerprint
+	img.metadata.Signature = &signature
+	if signature.Status == installer.SignatureVerified {
+		log.Infof("Image %s:%s is signed by publisher %s", name, tag, signature.Publisher)
+		return nil
+	}
+
+	log.Warnf("Image %s:%s failed signature verification (%s): %s", name, tag, signature.Status, signature.Message)
+	if c.verifier.policy == PolicyReject {
+		quarantine(name, tag, img.digest, []installer.Problem{{Severity: installer.SeverityError, Message: signature.Message}})
+		err = installer.RemoveVersion(name, tag, "")
+		if err != nil {
+			log.Errorf("Could not remove rejected version %s(%s): %s", name, tag, err.Error())
+		}
+		return errors.Wrapf(ErrUnsigned, "Rejected '%s'(%s)", name, tag)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/protosio/app-store/installer"
+	"github.com/protosio/app-store/thumbnail"
+)
+
+// thumbnailLabel holds either a http(s) URL or a data URI pointing to the installer thumbnail
+const thumbnailLabel = installer.MetadataLabelPrefix + "thumbnail"
+
+const (
+	// maxThumbnailLayers is the number of layers, starting with the topmost one, searched for a thumbnail
+	maxThumbnailLayers = 3
+	// maxThumbnailLayerSize is the size above which a layer is not searched for a thumbnail
+	maxThumbnailLayerSize = 32 << 20
+)
+
+// findThumbnail retrieves the installer thumbnail referenced by the image labels. If there is no thumbnail label,
+// the topmost layers are searched for a thumbnail at one of the well-known paths. Large layers are skipped, so
+// images without a thumbnail don't have to be downloaded entirely
+func findThumbnail(labels map[string]string, layers []ispec.Descriptor, openBlob func(digest.Digest) (io.ReadCloser, error)) ([]byte, bool, error) {
+	if value, found := labels[thumbnailLabel]; found {
+		data, err := thumbnail.FromLabel(value)
+		return data, err == nil, err
+	}
+
+	for i := len(layers) - 1; i >= 0 && i >= len(layers)-maxThumbnailLayers; i-- {
+		if layers[i].Size > maxThumbnailLayerSize {
+			continue
+		}
+		blob, err := openBlob(layers[i].Digest)
+		if err != nil {
+			return nil, false, err
+		}
+		data, found, err := thumbnail.FindInLayer(blob)
+		blob.Close()
+		if err != nil || found {
+			return data, found, err
+		}
+	}
+	return nil, false, nil
+}
+
+// openBlob opens a blob stored in the registry for streaming
+func (c *Client) openBlob(name string, d digest.Digest) (io.ReadCloser, error) {
+	url := c.url("/v2/%s/blobs/%s", name, d)
+	r, err := c.get(url, "")
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		r.Body.Close()
+		return nil, fmt.Errorf("%s returned %d", url, r.StatusCode)
+	}
+	return r.Body, nil
+}
+
+// Thumbnail retrieves the installer thumbnail of an archived image, from its labels or its layers
+func (a *Archive) Thumbnail() ([]byte, bool, error) {
+	manifest, err := decodeManifest(a.manifest, a.mediaType)
+	if err != nil {
+		return nil, false, err
+	}
+	return findThumbnail(a.config.Config.Labels, manifest.Layers, func(d digest.Digest) (io.ReadCloser, error) {
+		return os.Open(a.blobs[d])
+	})
+}
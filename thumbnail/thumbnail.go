@@ -0,0 +1,294 @@
+package thumbnail
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	// decoders for the supported thumbnail formats
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/pkg/errors"
+
+	"github.com/protosio/app-store/util"
+)
+
+var log = util.GetLogger()
+
+// MaxSize is the maximum size in bytes of a thumbnail image
+const MaxSize = 1 << 20
+
+// MaxDimension is the maximum width and height in pixels of a thumbnail image. It is checked before the image is
+// decoded, since a small compressed image can expand to a huge bitmap
+const MaxDimension = 2048
+
+// Original is the variant that holds the thumbnail as provided by the publisher
+const Original = "original"
+
+// Sizes maps the resized thumbnail variants to their maximum width and height in pixels
+var Sizes = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+// Paths are the well-known locations of a thumbnail inside an image filesystem, in order of preference
+var Paths = []string{"protos/thumbnail.png", "protos/thumbnail.jpg", "protos/thumbnail.jpeg"}
+
+// allowedHosts are the hosts thumbnails can be downloaded from. Downloads are disabled if it's empty
+var allowedHosts = []string{}
+
+// privateNetworks are the address ranges thumbnails are never downloaded from, so image labels can't be used to
+// reach internal services
+var privateNetworks = mustParseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkDialAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("Too many redirects")
+		}
+		return checkURL(req.URL)
+	},
+}
+
+// Variant is an encoded thumbnail image in a specific size
+type Variant struct {
+	Size        string
+	ContentType string
+	Data        []byte
+}
+
+// SetAllowedHosts sets the hosts thumbnails can be downloaded from. Subdomains of the provided hosts are allowed too
+func SetAllowedHosts(hosts []string) {
+	allowedHosts = hosts
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// publicIP checks if an IP address can be reached from the internet
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress refuses connections to non-public addresses. It runs after the host name is resolved, so it also
+// covers host names that resolve to internal addresses
+func checkDialAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("Thumbnail downloads from %s are not allowed", host)
+	}
+	return nil
+}
+
+// checkURL makes sure a thumbnail URL uses http(s) and points to one of the allowed hosts
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Thumbnail URL '%s' is not a http(s) URL", u)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Thumbnail downloads from %s are not allowed", host)
+}
+
+// FromLabel retrieves a thumbnail referenced by an image label, which can be either a data URI or a http(s) URL.
+// URLs are only downloaded from the allowed hosts, and never from private, loopback or link-local addresses
+func FromLabel(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "data:") {
+		return decodeDataURI(value)
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Thumbnail '%s' is neither a data URI nor a http(s) URL", value)
+	}
+	err = checkURL(u)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Downloading thumbnail from %s", value)
+	r, err := httpClient.Get(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to download thumbnail")
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Thumbnail download from %s returned %d", value, r.StatusCode)
+	}
+	return readLimited(r.Body)
+}
+
+// decodeDataURI decodes a base64 encoded data URI (data:image/png;base64,...)
+func decodeDataURI(uri string) ([]byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, "data:"), ",", 2)
+	if len(parts) != 2 || !strings.HasSuffix(parts[0], ";base64") {
+		return nil, errors.New("Thumbnail data URI must be base64 encoded")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode thumbnail data URI")
+	}
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("Thumbnail is larger than %d bytes", MaxSize)
+	}
+	return data, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("Thumbnail is larger than %d bytes", MaxSize)
+	}
+	return data, nil
+}
+
+// FindInLayer looks for a thumbnail at one of the well-known paths in an image layer, which can be a plain or a
+// gzip compressed tarball. The boolean indicates if a thumbnail was found
+func FindInLayer(layer io.Reader) ([]byte, bool, error) {
+	reader := bufio.NewReader(layer)
+	var tarReader io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, false, err
+		}
+		defer gz.Close()
+		tarReader = gz
+	}
+
+	tr := tar.NewReader(tarReader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if found, _ := util.StringInSlice(path.Clean(strings.TrimPrefix(header.Name, "/")), Paths); found {
+			data, err := readLimited(tr)
+			return data, err == nil, err
+		}
+	}
+}
+
+// Variants validates a thumbnail image and produces all its size variants. The original is kept as is, while the
+// resized variants are encoded as PNG. Images are never scaled up, and images larger than MaxDimension are rejected
+func Variants(data []byte) ([]Variant, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Thumbnail is not a valid PNG, JPEG or GIF image")
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, fmt.Errorf("Thumbnail is %dx%d pixels, larger than the maximum of %dx%d", cfg.Width, cfg.Height, MaxDimension, MaxDimension)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Thumbnail is not a valid PNG, JPEG or GIF image")
+	}
+	variants := []Variant{{Size: Original, ContentType: "image/" + format, Data: data}}
+	for size, pixels := range Sizes {
+		var buf bytes.Buffer
+		err = png.Encode(&buf, resize(img, pixels))
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{Size: size, ContentType: "image/png", Data: buf.Bytes()})
+	}
+	return variants, nil
+}
+
+// resize scales an image down so it fits in a square of the provided size, keeping its aspect ratio. Every
+// destination pixel is the average of the source pixels it covers
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+	dstWidth, dstHeight := size, size
+	if width > height {
+		dstHeight = maxInt(1, height*size/width)
+	} else {
+		dstWidth = maxInt(1, width*size/height)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/dstHeight, bounds.Min.Y+maxInt((y+1)*height/dstHeight, y*height/dstHeight+1)
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/dstWidth, bounds.Min.X+maxInt((x+1)*width/dstWidth, x*width/dstWidth+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -9,14 +9,29 @@ import (
 
 // Config is a struct that is used to share config params all over the code
 type Config struct {
-	Port         int
-	DBHost       string
-	DBName       string
-	DBUser       string
-	DBPass       string
-	DBPort       int
-	RegistryHost string
-	RegistryPort int
+	Port             int
+	DBHost           string
+	DBName           string
+	DBUser           string
+	DBPass           string
+	DBPort           int
+	RegistryHost     string
+	RegistryPort     int
+	RegistryScheme   string
+	RegistryCA       string
+	RegistryUser     string
+	RegistryPass     string
+	RegistryPageSize int
+	ScanConcurrency  int
+	EventSecret      string
+	EventSources     []string
+	InsecureEvents   bool
+	TrustedProxies   []string
+	ScanSchedule     string
+	SignatureKeys    string
+	SignaturePolicy  string
+	ChannelPatterns  []string
+	ThumbnailHosts   []string
 }
 
 // PortType defines a port type, that can hold TCP or UDP
@@ -41,6 +56,13 @@ var config = Config{
 	DBName: "installers",
 	DBUser: "root",
 	DBPort: 26257,
+
+	RegistryHost:     "docker-registry",
+	RegistryPort:     5000,
+	RegistryScheme:   "http",
+	RegistryPageSize: 100,
+	ScanConcurrency:  4,
+	SignaturePolicy:  "disabled",
 }
 
 // SetLogLevel sets the log level for the application